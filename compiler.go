@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/urfave/cli/v2"
@@ -145,19 +146,52 @@ func installPlugins(ctx *cli.Context) error {
 	}
 	pluginFile.Close()
 
-	return recompile(src)
+	if err := recompile(src); err != nil {
+		return err
+	}
+	for _, slug := range ctx.Args().Slice() {
+		lyra.GetCurrentProject().AddPlugin(slug)
+	}
+	return nil
 }
 
-func listPlugins(ctx *cli.Context) error {
+func installedPlugins() (plugins []string, err error) {
 	bytes, err := lyraSRC.ReadFile("plugins.go")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for _, line := range strings.Split(string(bytes), "\n") {
 		if strings.HasPrefix(line, "import _") {
-			println(strings.Replace(strings.Replace(line, "import _ \"", "", 1), "\"", "", 1))
+			plugins = append(plugins, strings.Replace(strings.Replace(line, "import _ \"", "", 1), "\"", "", 1))
+		}
+	}
+	return plugins, nil
+}
+
+// missingPlugins returns the plugins required by the current project that are not compiled into this binary.
+func missingPlugins() (missing []string, err error) {
+	installed, err := installedPlugins()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, required := range lyra.GetCurrentProject().Plugins() {
+		if !slices.Contains(installed, required) {
+			missing = append(missing, required)
 		}
 	}
+	return missing, nil
+}
+
+func listPlugins(ctx *cli.Context) error {
+	plugins, err := installedPlugins()
+	if err != nil {
+		return err
+	}
+
+	for _, plugin := range plugins {
+		println(plugin)
+	}
 	return nil
 }
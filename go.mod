@@ -3,6 +3,7 @@ module github.com/mrnavastar/lyra
 go 1.22.10
 
 require (
+	github.com/ProtonMail/go-crypto v1.1.3
 	github.com/codeclysm/extract v2.2.0+incompatible
 	github.com/mrnavastar/assist v0.0.0-20241110011458-91ecf862636a
 	github.com/mrnavastar/babe v0.0.0-20241019203637-8693d9e1507a
@@ -11,10 +12,13 @@ require (
 )
 
 require (
+	github.com/cloudflare/circl v1.3.7 // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.5 // indirect
 	github.com/h2non/filetype v1.1.3 // indirect
 	github.com/juju/errors v1.0.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/stretchr/testify v1.10.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
+	golang.org/x/crypto v0.31.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
@@ -10,17 +10,35 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"slices"
+	"strings"
 	"sync"
 )
 
 //----- [App] ----------------------------------------------------------------------------------------------------------
 
+var offline bool
+var refresh bool
+
 var app = cli.App{
 	Name:                   "lyra",
 	Args:                   true,
 	UseShortOptionHandling: true,
 	EnableBashCompletion:   true,
 	Suggest:                true,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "offline",
+			Usage:       "resolve everything from the cache without touching the network",
+			EnvVars:     []string{"LYRA_OFFLINE"},
+			Destination: &offline,
+		},
+		&cli.BoolFlag{
+			Name:        "refresh",
+			Usage:       "check repositories for updated metadata and snapshots, ignoring their update policies",
+			Destination: &refresh,
+		},
+	},
 	Authors: []*cli.Author{
 		{
 			Name:  "MrNavaStar",
@@ -60,21 +78,51 @@ func GetCache() (string, error) {
 	return path.Join(dir, "lyra"), nil
 }
 
+// GetConfig returns the full path to the user level lyra config directory, where credentials and settings live.
+func GetConfig() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, "lyra"), nil
+}
+
+// IsOffline reports whether lyra was asked to stay off the network, either by --offline or by LYRA_OFFLINE.
+func IsOffline() bool {
+	return offline
+}
+
+// IsRefreshing reports whether --refresh asked for every update policy to be ignored.
+func IsRefreshing() bool {
+	return refresh
+}
+
+// Sha256Sum returns the hex encoded sha256 digest of a file.
+func Sha256Sum(file string) (string, error) {
+	return HashFile(file, "sha256")
+}
+
 // PingResource returns true if a resource at a given endpoint is reachable without downloading the file
 func PingResource(uri *url.URL) bool {
-	request := http.Request{
-		Host:   uri.String(),
-		Method: "GET",
-		Header: http.Header{
-			"Range": {"bytes=0-5"},
-		},
+	if IsOffline() {
+		return false
 	}
-	client := &http.Client{}
-	response, err := client.Do(&request)
+	request, err := http.NewRequest("GET", uri.String(), nil)
 	if err != nil {
 		return false
 	}
-	return response.StatusCode == 200
+	request.Header.Set("Range", "bytes=0-5")
+
+	client, err := HttpClient()
+	if err != nil {
+		return false
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode == http.StatusOK || response.StatusCode == http.StatusPartialContent
 }
 
 //----- [Java] ---------------------------------------------------------------------------------------------------------
@@ -82,7 +130,8 @@ func PingResource(uri *url.URL) bool {
 type JavaAPI struct {
 	mu sync.Mutex
 
-	java string
+	java    string
+	version int
 }
 
 var Java JavaAPI
@@ -123,6 +172,17 @@ func (*CommandAPI) Register(command *cli.Command) {
 	Command.commands = append(Command.commands, command)
 }
 
+// AddFlags adds flags to a command that is already registered, so that plugins can extend the commands of lyra.
+func (*CommandAPI) AddFlags(name string, flags ...cli.Flag) {
+	Command.mu.Lock()
+	defer Command.mu.Unlock()
+	for _, command := range Command.commands {
+		if command.Name == name {
+			command.Flags = append(command.Flags, flags...)
+		}
+	}
+}
+
 // RegisterMany registers a list of commands for the current lyra session.
 func (*CommandAPI) RegisterMany(commands []*cli.Command) {
 	Command.mu.Lock()
@@ -135,19 +195,33 @@ func (*CommandAPI) RegisterMany(commands []*cli.Command) {
 type DependencyAPI struct {
 	mu sync.Mutex
 
-	repoAcceptors []func(uri url.URL) bool
-	parsers       []func(slug string) (Artifact, error)
-	resolvers     map[string]func(uri *url.URL) (string, error)
+	repoAcceptors  []func(uri url.URL) bool
+	parsers        []func(slug string, exclude []string) (Artifact, error)
+	resolvers      map[string]func(uri *url.URL) (string, error)
+	versionListers []func(artifact Artifact) ([]string, error)
+	updaters       []func(artifact Artifact) (Artifact, bool, error)
+	platforms      []func(platform Artifact) (map[string]string, error)
 }
 
-var mavenPattern = regexp.MustCompile("([^: ]+):([^: ]+)(:([^: ]*)(:([^: ]+))?)?:([^: ]+)")
+var mavenPattern = regexp.MustCompile("^([^: ]+):([^: ]+)(:([^: ]*)(:([^: ]+))?)?:([^: ]+)$")
 var Dependency DependencyAPI
 
 func (*DependencyAPI) RegisterRepoAcceptor(acceptor func(uri url.URL) bool) {
 	Dependency.repoAcceptors = append(Dependency.repoAcceptors, acceptor)
 }
 
+// RegisterParser registers a function that reads a slug into an artifact. The exclusions of the artifact are applied
+// to the graph it returns, see RegisterExcludingParser for parsers that need them while resolving.
 func (*DependencyAPI) RegisterParser(parser func(slug string) (Artifact, error)) {
+	Dependency.RegisterExcludingParser(func(slug string, _ []string) (Artifact, error) {
+		return parser(slug)
+	})
+}
+
+// RegisterExcludingParser registers a function that reads a slug into an artifact. Exclude holds the group:name
+// patterns left out of the dependencies of the artifact, so a parser that resolves a dependency graph never has to
+// fetch them.
+func (*DependencyAPI) RegisterExcludingParser(parser func(slug string, exclude []string) (Artifact, error)) {
 	Dependency.mu.Lock()
 	defer Dependency.mu.Unlock()
 	Dependency.parsers = append(Dependency.parsers, parser)
@@ -162,17 +236,154 @@ func (*DependencyAPI) RegisterResolver(scheme string, resolver func(uri *url.URL
 	Dependency.resolvers[scheme] = resolver
 }
 
+// RegisterVersionLister registers a function that lists every published version of an artifact.
+func (*DependencyAPI) RegisterVersionLister(lister func(artifact Artifact) ([]string, error)) {
+	Dependency.mu.Lock()
+	defer Dependency.mu.Unlock()
+	Dependency.versionListers = append(Dependency.versionListers, lister)
+}
+
+// RegisterUpdater registers a function that re-resolves a changing artifact, like a snapshot, once its repository
+// update policy says it is due. It returns false if the artifact is not one it handles or did not change.
+func (*DependencyAPI) RegisterUpdater(updater func(artifact Artifact) (Artifact, bool, error)) {
+	Dependency.mu.Lock()
+	defer Dependency.mu.Unlock()
+	Dependency.updaters = append(Dependency.updaters, updater)
+}
+
+// RegisterPlatformReader registers a function that reads the versions a platform, like a maven bom, manages. The
+// result maps the Key of every managed artifact to its version.
+func (*DependencyAPI) RegisterPlatformReader(reader func(platform Artifact) (map[string]string, error)) {
+	Dependency.mu.Lock()
+	defer Dependency.mu.Unlock()
+	Dependency.platforms = append(Dependency.platforms, reader)
+}
+
+// ManagedVersions returns the versions a platform manages, as read by the first platform reader that understands it.
+func (*DependencyAPI) ManagedVersions(platform Artifact) (map[string]string, error) {
+	var errs []error
+	for _, reader := range Dependency.platforms {
+		managed, err := reader(platform)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return managed, nil
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no platform reader registered")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// ListVersions returns every version of an artifact known to any registered version lister.
+func (*DependencyAPI) ListVersions(artifact Artifact) (versions []string, err error) {
+	var errs []error
+	for _, lister := range Dependency.versionListers {
+		listed, err := lister(artifact)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, version := range listed {
+			if !slices.Contains(versions, version) {
+				versions = append(versions, version)
+			}
+		}
+	}
+	if len(versions) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return versions, nil
+}
+
+// ResolveURI runs a uri through the registered resolvers and returns the path of the resulting local file.
+func (*DependencyAPI) ResolveURI(uri string) (string, error) {
+	return Artifact{}.resolve(uri)
+}
+
+// ParseMavenCoordinate reads group:name, group:name:version, group:name:packaging:version and
+// group:name:packaging:classifier:version. The gradle style group:name:version:classifier, which launcher metadata
+// uses too, is recognised by its version starting with a digit, and a trailing @packaging is accepted as well.
 func (*DependencyAPI) ParseMavenCoordinate(coordinate string) (artifact Artifact) {
+	if base, packaging, ok := strings.Cut(coordinate, "@"); ok && !isCoordinatePath(packaging) {
+		coordinate = base
+		artifact.Packaging = packaging
+	}
+
 	groups := mavenPattern.FindStringSubmatch(coordinate)
+	if groups == nil {
+		// group:name without a version
+		if parts := strings.Split(coordinate, ":"); len(parts) == 2 && parts[0] != "" && parts[1] != "" && !isCoordinatePath(coordinate) {
+			artifact.Group = parts[0]
+			artifact.Name = parts[1]
+		}
+		return artifact
+	}
+	if isCoordinatePath(coordinate) {
+		return Artifact{}
+	}
 	artifact.Name = groups[2]
 	artifact.Group = groups[1]
-
-	if len(groups) == 8 {
-		artifact.Version = groups[7]
+	artifact.Version = groups[7]
+
+	switch {
+	case groups[5] != "":
+		artifact.Packaging = groups[4]
+		artifact.Classifier = groups[6]
+	case groups[3] != "" && startsWithDigit(groups[4]):
+		artifact.Version = groups[4]
+		artifact.Classifier = groups[7]
+	case groups[3] != "":
+		artifact.Packaging = groups[4]
+	}
+	if artifact.Packaging == "jar" {
+		artifact.Packaging = ""
 	}
 	return artifact
 }
 
+//----- [TestAPI] ------------------------------------------------------------------------------------------------------
+
+type TestHooks struct {
+	preTest  []func(*cli.Context, *TestOptions) error
+	postTest []func(*cli.Context, TestOptions) error
+}
+
+// TestAPI runs the tests of the project, see TestSources for where they live.
+type TestAPI struct {
+	mu sync.Mutex
+
+	Hooks TestHooks
+}
+
+var Test TestAPI
+
+// PreTest registers a hook that can change the options of lyra test before the tests run, like adding a java agent.
+func (TestHooks) PreTest(hook func(*cli.Context, *TestOptions) error) {
+	Test.mu.Lock()
+	defer Test.mu.Unlock()
+	Test.Hooks.preTest = append(Test.Hooks.preTest, hook)
+}
+
+// PostTest registers a hook that runs once every test of lyra test passed.
+func (TestHooks) PostTest(hook func(*cli.Context, TestOptions) error) {
+	Test.mu.Lock()
+	defer Test.mu.Unlock()
+	Test.Hooks.postTest = append(Test.Hooks.postTest, hook)
+}
+
+//----- [CacheAPI] -----------------------------------------------------------------------------------------------------
+
+// CacheAPI is a content addressed store of downloaded files, with an index from url to digest.
+type CacheAPI struct {
+	mu sync.Mutex
+
+	index map[string]CacheEntry
+}
+
+var Cache CacheAPI
+
 //----- [BuildAPI] -----------------------------------------------------------------------------------------------------
 
 type BuildHooks struct {
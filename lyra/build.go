@@ -8,6 +8,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	fss "github.com/mrnavastar/assist/fs"
@@ -47,6 +48,9 @@ func getNewestTime(directory string) (time.Time, error) {
 	var newest time.Time
 
 	if err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 		if d.IsDir() {
 			return nil
 		}
@@ -72,6 +76,8 @@ type BuildOptions struct {
 	Sources  bool
 	Minimize bool
 	Docs     bool
+	// Tests compiles the test source set of every module as well, see TestSources
+	Tests bool
 }
 
 func build(ctx *cli.Context) error {
@@ -97,43 +103,46 @@ func (*BuildAPI) Project(options BuildOptions) error {
 		return err
 	}
 
-	classpath, err := project.GetClasspath()
+	classpath, err := project.GetCompileClasspath()
+	if err != nil {
+		return err
+	}
+	processorPath, err := project.GetProcessorPath()
 	if err != nil {
 		return err
 	}
+	var testClasspath []string
+	if options.Tests {
+		if testClasspath, err = project.GetTestClasspath(); err != nil {
+			return err
+		}
+	}
 
 	for _, module := range files {
+		// src/test holds the tests of src/main, not a module of its own
+		if isTestModule(module.Name()) {
+			continue
+		}
 		project.GoWith("lyra:build", func() error {
-			// Create Sourcepath
-			var sources []string
-			if err := filepath.WalkDir(path.Join("src", module.Name(), "java"), func(path string, d fs.DirEntry, err error) error {
-				if d.IsDir() {
-					return nil
-				}
-
-				if strings.HasSuffix(path, ".java") {
-					sources = append(sources, path)
-				}
-				return nil
-			}); err != nil {
+			// Only the sources that changed are compiled again, along with the sources depending on them
+			output := path.Join("build/output", module.Name())
+			compiled, err := compileIncremental(path.Join("src", module.Name(), "java"), compileStateFile(module.Name(), false), JavaCompileOptions{
+				Output:        output,
+				Classpath:     classpath,
+				ProcessorPath: processorPath,
+			})
+			if err != nil {
 				return err
 			}
+			outputTime := time.Now()
+			if !compiled {
+				outputTime, _ = getNewestTime(output)
+			}
 
-			// Only recompile if the source code is newer than the already compiled code
-			sourceTime, _ := getNewestTime(path.Join("src", module.Name(), "java"))
-			outputTime, _ := getNewestTime(path.Join("build/output", module.Name()))
-			if outputTime.Before(sourceTime) {
-				if err := os.RemoveAll(path.Join("build/output", module.Name())); err != nil {
-					return err
-				}
-
-				if err := Java.Compile(JavaCompileOptions{
-					Classpath: classpath,
-					Sources:   sources,
-				}); err != nil {
+			if options.Tests {
+				if err := compileTests(module.Name(), testClasspath, processorPath); err != nil {
 					return err
 				}
-				outputTime = time.Now()
 			}
 
 			if options.Jar {
@@ -153,6 +162,24 @@ func (*BuildAPI) Project(options BuildOptions) error {
 	return project.WaitFor("lyra:build")
 }
 
+// javaSources returns every java file below a directory.
+func javaSources(directory string) (sources []string, err error) {
+	err = filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		if strings.HasSuffix(path, ".java") {
+			sources = append(sources, path)
+		}
+		return nil
+	})
+	return sources, err
+}
+
 func Package(name string, outputTime time.Time, fat bool) error {
 	filename := path.Join("build/jar", name+".jar")
 	resources := path.Join("src", name, "resources")
@@ -164,6 +191,9 @@ func Package(name string, outputTime time.Time, fat bool) error {
 		return nil
 	}
 
+	if err := os.MkdirAll(path.Dir(filename), os.ModePerm); err != nil {
+		return err
+	}
 	jar := babe.CreateJar(filename)
 	for _, hook := range Build.Hooks.prePackageJar {
 		if err := hook(jar); err != nil {
@@ -174,20 +204,14 @@ func Package(name string, outputTime time.Time, fat bool) error {
 	// Package resources async
 	if fss.Exists(resources) {
 		jar.Task(func(jar *babe.Jar) error {
-			return fss.Cwd(resources, func() error {
-				return filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
-					if d.IsDir() {
-						return nil
+			return walkMembers(resources, func(file string, entry string) {
+				jar.Task(func(jar *babe.Jar) error {
+					member, err := babe.JarMemberFromFile(file)
+					if err != nil {
+						return err
 					}
-
-					jar.Task(func(jar *babe.Jar) error {
-						member, err := babe.JarMemberFromFile(path)
-						if err != nil {
-							return err
-						}
-						jar.Add(member)
-						return nil
-					})
+					member.Name = entry
+					jar.Add(member)
 					return nil
 				})
 			})
@@ -196,43 +220,62 @@ func Package(name string, outputTime time.Time, fat bool) error {
 
 	// Package class files async
 	jar.Task(func(jar *babe.Jar) error {
-		return fss.Cwd(path.Join("build/output", name), func() error {
-			return filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
-				if d.IsDir() {
-					return nil
+		return walkMembers(path.Join("build/output", name), func(file string, entry string) {
+			jar.Task(func(jar *babe.Jar) error {
+				member, err := babe.JarMemberFromFile(file)
+				if err != nil {
+					return err
 				}
+				member.Name = entry
 
-				jar.Task(func(jar *babe.Jar) error {
-					member, err := babe.JarMemberFromFile(path)
-					if err != nil {
-						return err
-					}
+				class, err := member.GetAsClass()
+				if err != nil {
+					return err
+				}
 
-					class, err := member.GetAsClass()
+				for _, hook := range Build.Hooks.packageClass {
+					err := hook(*jar, &class)
 					if err != nil {
 						return err
 					}
+				}
+				var b []byte
+				class.Write(&b)
+				member.Buffer = &bytes.Buffer{Data: &b, Index: 0}
+				jar.Add(member)
 
-					for _, hook := range Build.Hooks.packageClass {
-						err := hook(*jar, &class)
-						if err != nil {
-							return err
-						}
-					}
-					var b []byte
-					class.Write(&b)
-					member.Buffer = &bytes.Buffer{Data: &[]byte{}, Index: 0}
-					jar.Add(member)
-
-					return nil
-				})
 				return nil
 			})
 		})
 	})
 
-	if err := jar.Wait(); err != nil {
-		return err
+	// Bundle the runtime classpath into fat jars, the first artifact to provide a file wins
+	if fat {
+		classpath, err := GetCurrentProject().GetRuntimeClasspath()
+		if err != nil {
+			return err
+		}
+
+		var mu sync.Mutex
+		bundled := map[string]bool{}
+		for _, dependency := range classpath {
+			jar.Task(func(jar *babe.Jar) error {
+				return forClasspathMember(dependency, func(member *babe.JarMember) error {
+					if isJarMetadata(member.Name) {
+						return nil
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+					if bundled[member.Name] {
+						return nil
+					}
+					bundled[member.Name] = true
+					jar.Add(*member)
+					return nil
+				})
+			})
+		}
 	}
 
 	// Create manifest
@@ -240,10 +283,69 @@ func Package(name string, outputTime time.Time, fat bool) error {
 	for field, value := range Build.manifestEntries {
 		manifest += fmt.Sprintf("%s: %s\n", field, value)
 	}
-	jar.Add(babe.JarMemberFromString("META-INF/MANIFEST.MF", manifest))
+	jar.Task(func(jar *babe.Jar) error {
+		jar.Add(babe.JarMemberFromString("META-INF/MANIFEST.MF", manifest))
+		return nil
+	})
+	return jar.Wait()
+}
+
+// walkMembers calls add for every file below a directory with the name it gets inside a jar. It does not change the
+// working directory, so that several modules can be packaged at once.
+func walkMembers(directory string, add func(file string, name string)) error {
+	return filepath.WalkDir(directory, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(directory, file)
+		if err != nil {
+			return err
+		}
+		add(file, filepath.ToSlash(name))
+		return nil
+	})
+}
+
+// forClasspathMember calls iter for every file of a classpath entry, which is either a jar or a directory of classes.
+func forClasspathMember(entry string, iter func(*babe.JarMember) error) error {
+	if info, err := os.Stat(entry); err != nil || !info.IsDir() {
+		return babe.ForJarMember(entry, iter)
+	}
+
+	var files, names []string
+	if err := walkMembers(entry, func(file string, name string) {
+		files = append(files, file)
+		names = append(names, name)
+	}); err != nil {
+		return err
+	}
+	for i, file := range files {
+		member, err := babe.JarMemberFromFile(file)
+		if err != nil {
+			return err
+		}
+		member.Name = names[i]
+		if err := iter(&member); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// isJarMetadata reports whether a jar member describes its own jar (manifest and signatures) and should not be
+// copied into another jar.
+func isJarMetadata(name string) bool {
+	upper := strings.ToUpper(name)
+	if !strings.HasPrefix(upper, "META-INF/") || strings.Count(upper, "/") > 1 {
+		return false
+	}
+	return upper == "META-INF/MANIFEST.MF" || strings.HasSuffix(upper, ".SF") || strings.HasSuffix(upper, ".RSA") ||
+		strings.HasSuffix(upper, ".DSA") || strings.HasSuffix(upper, ".EC")
+}
+
 func PackageSources(name string, outputTime time.Time) error {
 	filename := path.Join("build/jar", name+"-sources.jar")
 
@@ -253,25 +355,25 @@ func PackageSources(name string, outputTime time.Time) error {
 		return nil
 	}
 
+	if err := os.MkdirAll(path.Dir(filename), os.ModePerm); err != nil {
+		return err
+	}
 	jar := babe.CreateJar(filename)
-	if err := fss.Cwd(path.Join("src", name, "java"), func() error {
-		return filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
-			if d.IsDir() || !strings.HasSuffix(path, ".java") {
-				return nil
+	jar.Task(func(jar *babe.Jar) error {
+		return walkMembers(path.Join("src", name, "java"), func(file string, entry string) {
+			if !strings.HasSuffix(entry, ".java") {
+				return
 			}
-
 			jar.Task(func(jar *babe.Jar) error {
-				member, err := babe.JarMemberFromFile(path)
+				member, err := babe.JarMemberFromFile(file)
 				if err != nil {
 					return err
 				}
+				member.Name = entry
 				jar.Add(member)
 				return nil
 			})
-			return nil
 		})
-	}); err != nil {
-		return err
-	}
+	})
 	return jar.Wait()
 }
@@ -0,0 +1,792 @@
+package lyra
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mrnavastar/assist/fs"
+	"github.com/urfave/cli/v2"
+)
+
+// CacheEntry records which stored file was downloaded from a url.
+type CacheEntry struct {
+	Digest   string
+	File     string
+	Size     int64
+	Fetched  time.Time
+	LastUsed time.Time
+}
+
+func init() {
+	Command.Register(&cli.Command{
+		Name: "cache",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "ls",
+				Args:   false,
+				Action: cacheList,
+			},
+			{
+				Name:   "size",
+				Args:   false,
+				Action: cacheSize,
+			},
+			{
+				Name:   "prune",
+				Args:   false,
+				Action: cachePrune,
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "days",
+						Usage: "also prune entries that have not been used, and files beside the store that have not been modified, for this many days",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "only print what would be pruned",
+					},
+				},
+			},
+			{
+				Name:   "verify",
+				Args:   false,
+				Action: cacheVerify,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "delete",
+						Usage: "remove corrupt entries so they are downloaded again",
+					},
+				},
+			},
+		},
+	})
+}
+
+// Used returns when the entry was last looked up, entries written before this was tracked fall back to when they
+// were fetched.
+func (entry CacheEntry) Used() time.Time {
+	if entry.LastUsed.IsZero() {
+		return entry.Fetched
+	}
+	return entry.LastUsed
+}
+
+// cacheTree is a directory of the cache beside the store, written by older versions of lyra or derived from stored
+// and remote files. Nothing records when its files were last used, so pruning goes by their modification time.
+type cacheTree struct {
+	dir string
+	// items lists what is listed and pruned one at a time, relative to the cache
+	items func(cache string, dir string) ([]string, error)
+	// redundant reports whether an item can go no matter how old it is, because it is recreated whenever needed
+	redundant func(item string, entries map[string]CacheEntry) bool
+}
+
+var cacheTrees = []cacheTree{
+	// Files downloaded before the store existed, they are adopted into the store on their next use
+	{dir: "libs", items: treeFiles, redundant: func(item string, entries map[string]CacheEntry) bool {
+		host, file, _ := strings.Cut(strings.TrimPrefix(item, "libs/http/"), "/")
+		for _, scheme := range []string{"https", "http"} {
+			if _, ok := entries[scheme+"://"+host+"/"+file]; ok {
+				return true
+			}
+		}
+		return false
+	}},
+	// Classes extracted from aars, keyed by the digest of the aar
+	{dir: "aar", items: treeDirs, redundant: func(item string, entries map[string]CacheEntry) bool {
+		for _, entry := range entries {
+			if entry.Digest == path.Base(item) {
+				return false
+			}
+		}
+		return true
+	}},
+	{dir: "git", items: treeDirs},
+	{dir: "minecraft", items: treeFiles},
+	// The plugin sources are written again whenever plugins are compiled
+	{dir: "plugin", items: treeRoot, redundant: func(string, map[string]CacheEntry) bool { return true }},
+}
+
+// treeFiles makes every file below a tree an item.
+func treeFiles(cache string, dir string) (items []string, err error) {
+	err = walkMembers(path.Join(cache, dir), func(file string, name string) {
+		items = append(items, path.Join(dir, name))
+	})
+	return items, err
+}
+
+// treeDirs makes every directory right below a tree an item.
+func treeDirs(cache string, dir string) (items []string, err error) {
+	entries, err := os.ReadDir(path.Join(cache, dir))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			items = append(items, path.Join(dir, entry.Name()))
+		}
+	}
+	return items, err
+}
+
+// treeRoot makes the whole tree a single item.
+func treeRoot(cache string, dir string) ([]string, error) {
+	return []string{dir}, nil
+}
+
+// treeItem is an item of a cacheTree with its size and the time its newest file was modified.
+type treeItem struct {
+	tree     cacheTree
+	path     string
+	size     int64
+	modified time.Time
+}
+
+// treeItems returns the items of every cacheTree that exists.
+func treeItems() (items []treeItem, err error) {
+	cache, err := GetCache()
+	if err != nil {
+		return nil, err
+	}
+	for _, tree := range cacheTrees {
+		if !fs.Exists(path.Join(cache, tree.dir)) {
+			continue
+		}
+		paths, err := tree.items(cache, tree.dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range paths {
+			size, err := directorySize(path.Join(cache, item))
+			if err != nil {
+				return nil, err
+			}
+			modified, err := getNewestTime(path.Join(cache, item))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, treeItem{tree: tree, path: item, size: size, modified: modified})
+		}
+	}
+	return items, nil
+}
+
+// storeDir returns the root of the content addressed store, files live at sha256/<ab>/<digest>/<name> below it.
+func storeDir() (string, error) {
+	cache, err := GetCache()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(cache, "store"), nil
+}
+
+// legacyCachePath returns where older versions of lyra stored the file downloaded from a url.
+func legacyCachePath(uri *url.URL) (string, error) {
+	cache, err := GetCache()
+	if err != nil {
+		return "", err
+	}
+
+	file := filepath.Base(uri.Path)
+	urlPath := strings.TrimSuffix(uri.Path, file)
+	return path.Join(cache, "libs", "http", uri.Host, urlPath, file), nil
+}
+
+// staleIndexLock is how old the lock of the index has to be before it is taken over, writing the index takes far
+// less. Only a crashed process leaves its lock behind for that long.
+const staleIndexLock = 30 * time.Second
+
+func (*CacheAPI) load() error {
+	if Cache.index != nil {
+		return nil
+	}
+
+	store, err := storeDir()
+	if err != nil {
+		return err
+	}
+	index := map[string]CacheEntry{}
+	indexFile := path.Join(store, "index.json")
+	if fs.Exists(indexFile) {
+		data, err := os.ReadFile(indexFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &index); err != nil {
+			return fmt.Errorf("failed to read the cache index %s: %w", indexFile, err)
+		}
+	}
+	Cache.index = index
+	return nil
+}
+
+// lockIndex keeps other lyra processes from changing the index until the returned function is called.
+func lockIndex(store string) (func(), error) {
+	lock := path.Join(store, "index.lock")
+	for {
+		file, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.ModePerm)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > staleIndexLock {
+			os.Remove(lock)
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// update changes the index while holding its lock. The index is read again first, so that the entries other lyra
+// processes stored or removed in the meantime are kept as they are.
+func (*CacheAPI) update(change func(index map[string]CacheEntry, store string) error) error {
+	store, err := storeDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(store, os.ModePerm); err != nil {
+		return err
+	}
+	unlock, err := lockIndex(store)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	Cache.index = nil
+	if err := Cache.load(); err != nil {
+		return err
+	}
+	if err := change(Cache.index, store); err != nil {
+		return err
+	}
+	return Cache.save(store)
+}
+
+// save writes the index through a temporary file so that a crash never leaves a truncated index behind. It must only
+// be called by update.
+func (*CacheAPI) save(store string) error {
+	data, err := json.MarshalIndent(Cache.index, "", "    ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(store, "index-*.json")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	temp.Close()
+	return os.Rename(temp.Name(), path.Join(store, "index.json"))
+}
+
+// Lookup returns the stored file for a url if it is cached.
+func (*CacheAPI) Lookup(uri string) (string, bool, error) {
+	Cache.mu.Lock()
+	defer Cache.mu.Unlock()
+	if err := Cache.load(); err != nil {
+		return "", false, err
+	}
+
+	entry, ok := Cache.index[uri]
+	if !ok {
+		return "", false, nil
+	}
+	store, err := storeDir()
+	if err != nil {
+		return "", false, err
+	}
+	file := path.Join(store, entry.File)
+	if !fs.Exists(file) {
+		return "", false, nil
+	}
+
+	// Last use only needs to be roughly right for pruning, so avoid rewriting the index on every lookup
+	if time.Since(entry.Used()) > time.Hour {
+		err := Cache.update(func(index map[string]CacheEntry, store string) error {
+			if entry, ok := index[uri]; ok {
+				entry.LastUsed = time.Now()
+				index[uri] = entry
+			}
+			return nil
+		})
+		if err != nil {
+			return "", false, err
+		}
+	}
+	return file, true, nil
+}
+
+// Entry returns the index entry of a url, without checking that its file still exists.
+func (*CacheAPI) Entry(uri string) (CacheEntry, bool) {
+	Cache.mu.Lock()
+	defer Cache.mu.Unlock()
+	if err := Cache.load(); err != nil {
+		return CacheEntry{}, false
+	}
+	entry, ok := Cache.index[uri]
+	return entry, ok
+}
+
+// Entries returns a copy of the cache index.
+func (*CacheAPI) Entries() (map[string]CacheEntry, error) {
+	Cache.mu.Lock()
+	defer Cache.mu.Unlock()
+	if err := Cache.load(); err != nil {
+		return nil, err
+	}
+
+	entries := map[string]CacheEntry{}
+	for uri, entry := range Cache.index {
+		entries[uri] = entry
+	}
+	return entries, nil
+}
+
+// Store moves a file into the store and records it as the content of a url.
+func (*CacheAPI) Store(uri string, file string, name string) (string, error) {
+	digest, err := Sha256Sum(file)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	store, err := storeDir()
+	if err != nil {
+		return "", err
+	}
+
+	relative := path.Join("sha256", digest[:2], digest, name)
+	stored := path.Join(store, relative)
+
+	// The file is moved while the index is locked, so that no other process removes it before it is indexed
+	Cache.mu.Lock()
+	defer Cache.mu.Unlock()
+	return stored, Cache.update(func(index map[string]CacheEntry, store string) error {
+		if err := os.MkdirAll(path.Dir(stored), os.ModePerm); err != nil {
+			return err
+		}
+		if fs.Exists(stored) {
+			os.Remove(file)
+		} else if err := os.Rename(file, stored); err != nil {
+			return err
+		}
+		index[uri] = CacheEntry{
+			Digest:   digest,
+			File:     relative,
+			Size:     info.Size(),
+			Fetched:  time.Now(),
+			LastUsed: time.Now(),
+		}
+		return nil
+	})
+}
+
+// Remove forgets a url, the stored file is deleted once no other url refers to it.
+func (*CacheAPI) Remove(uri string) error {
+	Cache.mu.Lock()
+	defer Cache.mu.Unlock()
+	return Cache.update(func(index map[string]CacheEntry, store string) error {
+		entry, ok := index[uri]
+		if !ok {
+			return nil
+		}
+		delete(index, uri)
+		for _, other := range index {
+			if other.File == entry.File {
+				return nil
+			}
+		}
+
+		file := path.Join(store, entry.File)
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// The digest directory is shared by every name the same content was downloaded under
+		os.Remove(path.Dir(file))
+		return nil
+	})
+}
+
+// Download returns the stored file for a url, fetching it first if it isn't cached. With refresh set, the url is
+// fetched again even if it is cached, unless lyra is offline.
+func (*CacheAPI) Download(uri *url.URL, refresh bool) (string, error) {
+	key := uri.String()
+	name := path.Base(uri.Path)
+	if !refresh || IsOffline() {
+		file, ok, err := Cache.Lookup(key)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return file, nil
+		}
+
+		// Adopt files downloaded before the store existed
+		legacy, err := legacyCachePath(uri)
+		if err == nil && fs.Exists(legacy) {
+			temp, err := copyToTemp(legacy)
+			if err != nil {
+				return "", err
+			}
+			return Cache.Store(key, temp, name)
+		}
+	}
+	if IsOffline() {
+		return "", fmt.Errorf("%s is not cached and lyra is offline", key)
+	}
+
+	store, err := storeDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(store, os.ModePerm); err != nil {
+		return "", err
+	}
+	temp, err := os.CreateTemp(store, "download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(temp.Name())
+
+	response, err := HttpGet(key)
+	if err != nil {
+		temp.Close()
+		return "", err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(temp, response.Body)
+	temp.Close()
+	if err != nil {
+		return "", err
+	}
+	if err := verifyRepositoryChecksum(key, temp.Name()); err != nil {
+		return "", err
+	}
+	return Cache.Store(key, temp.Name(), name)
+}
+
+func copyToTemp(file string) (string, error) {
+	store, err := storeDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(store, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	source, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	temp, err := os.CreateTemp(store, "import-*")
+	if err != nil {
+		return "", err
+	}
+	defer temp.Close()
+	if _, err := io.Copy(temp, source); err != nil {
+		os.Remove(temp.Name())
+		return "", err
+	}
+	return temp.Name(), nil
+}
+
+// RegisterProject remembers a project directory, so that pruning the cache keeps everything its lockfile needs.
+func (*CacheAPI) RegisterProject(dir string) error {
+	projects, err := knownProjects()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(projects, dir) {
+		return nil
+	}
+	return saveKnownProjects(append(projects, dir))
+}
+
+func knownProjects() (projects []string, err error) {
+	cache, err := GetCache()
+	if err != nil {
+		return nil, err
+	}
+	file := path.Join(cache, "projects.json")
+	if !fs.Exists(file) {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return projects, json.Unmarshal(data, &projects)
+}
+
+func saveKnownProjects(projects []string) error {
+	cache, err := GetCache()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cache, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(projects, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(cache, "projects.json"), data, os.ModePerm)
+}
+
+// lockedURLs collects every url pinned by the lockfile of a known project, projects that no longer exist are
+// forgotten.
+func lockedURLs() (map[string]bool, error) {
+	projects, err := knownProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	locked := map[string]bool{}
+	var existing []string
+	for _, project := range projects {
+		if !fs.Exists(path.Join(project, "lyra.json")) {
+			continue
+		}
+		existing = append(existing, project)
+
+		data, err := os.ReadFile(path.Join(project, "lyra.lock"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		proxy := lockProxy{}
+		if err := json.Unmarshal(data, &proxy); err != nil {
+			return nil, fmt.Errorf("failed to read lockfile of %s: %s", project, err)
+		}
+		for _, artifact := range proxy.Artifacts {
+			locked[artifact.URL] = true
+		}
+	}
+	if len(existing) != len(projects) {
+		if err := saveKnownProjects(existing); err != nil {
+			return nil, err
+		}
+	}
+	return locked, nil
+}
+
+func formatSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+func directorySize(directory string) (size int64, err error) {
+	if !fs.Exists(directory) {
+		return 0, nil
+	}
+	err = filepath.WalkDir(directory, func(path string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+func sortedEntries() ([]string, map[string]CacheEntry, error) {
+	entries, err := Cache.Entries()
+	if err != nil {
+		return nil, nil, err
+	}
+	var uris []string
+	for uri := range entries {
+		uris = append(uris, uri)
+	}
+	slices.Sort(uris)
+	return uris, entries, nil
+}
+
+func cacheList(ctx *cli.Context) error {
+	uris, entries, err := sortedEntries()
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "URL	SIZE	LAST USED")
+	for _, uri := range uris {
+		entry := entries[uri]
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", uri, formatSize(entry.Size), entry.Used().Format(time.DateTime))
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	items, err := treeItems()
+	if err != nil || len(items) == 0 {
+		return err
+	}
+	fmt.Println()
+	writer = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "PATH	SIZE	LAST MODIFIED")
+	for _, item := range items {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", item.path, formatSize(item.size), item.modified.Format(time.DateTime))
+	}
+	return writer.Flush()
+}
+
+func cacheSize(ctx *cli.Context) error {
+	cache, err := GetCache()
+	if err != nil {
+		return err
+	}
+	dirs, err := os.ReadDir(cache)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var total int64
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, dir := range dirs {
+		size, err := directorySize(path.Join(cache, dir.Name()))
+		if err != nil {
+			return err
+		}
+		total += size
+		fmt.Fprintf(writer, "%s\t%s\n", dir.Name(), formatSize(size))
+	}
+	fmt.Fprintf(writer, "total\t%s\n", formatSize(total))
+	return writer.Flush()
+}
+
+func cachePrune(ctx *cli.Context) error {
+	locked, err := lockedURLs()
+	if err != nil {
+		return err
+	}
+	uris, entries, err := sortedEntries()
+	if err != nil {
+		return err
+	}
+
+	days := ctx.Int("days")
+	var freed int64
+	for _, uri := range uris {
+		entry := entries[uri]
+		expired := days > 0 && time.Since(entry.Used()) > time.Duration(days)*24*time.Hour
+		// Lockfiles only pin the files that end up on a classpath, the metadata used to resolve them is kept until
+		// it expires so that locked projects keep working offline
+		if (locked[uri] || isMetadata(uri)) && !expired {
+			continue
+		}
+
+		fmt.Printf("pruning %s (%s)\n", uri, formatSize(entry.Size))
+		freed += entry.Size
+		if ctx.Bool("dry-run") {
+			continue
+		}
+		if err := Cache.Remove(uri); err != nil {
+			return err
+		}
+	}
+
+	// The trees beside the store are not pinned by lockfiles, only what is recreated anyway goes without --days
+	items, err := treeItems()
+	if err != nil {
+		return err
+	}
+	cache, err := GetCache()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		expired := days > 0 && time.Since(item.modified) > time.Duration(days)*24*time.Hour
+		if !expired && (item.tree.redundant == nil || !item.tree.redundant(item.path, entries)) {
+			continue
+		}
+
+		fmt.Printf("pruning %s (%s)\n", item.path, formatSize(item.size))
+		freed += item.size
+		if ctx.Bool("dry-run") {
+			continue
+		}
+		if err := os.RemoveAll(path.Join(cache, item.path)); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("freed %s\n", formatSize(freed))
+	return nil
+}
+
+func isMetadata(uri string) bool {
+	return strings.HasSuffix(uri, ".pom") || strings.HasSuffix(uri, ".xml") || strings.HasSuffix(uri, ".module")
+}
+
+func cacheVerify(ctx *cli.Context) error {
+	uris, entries, err := sortedEntries()
+	if err != nil {
+		return err
+	}
+	store, err := storeDir()
+	if err != nil {
+		return err
+	}
+
+	corrupt := 0
+	for _, uri := range uris {
+		entry := entries[uri]
+		digest, err := Sha256Sum(path.Join(store, entry.File))
+		if err == nil && digest == entry.Digest {
+			continue
+		}
+
+		corrupt++
+		if err != nil {
+			fmt.Printf("%s: %s\n", uri, err)
+		} else {
+			fmt.Printf("%s: expected sha256 %s but got %s\n", uri, entry.Digest, digest)
+		}
+		if ctx.Bool("delete") {
+			if err := Cache.Remove(uri); err != nil {
+				return err
+			}
+		}
+	}
+	if corrupt > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d cached files failed verification", corrupt, len(uris)), 1)
+	}
+	fmt.Printf("verified %d cached files\n", len(uris))
+	return nil
+}
@@ -0,0 +1,173 @@
+package lyra
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/mrnavastar/assist/fs"
+)
+
+// checksumAlgorithms are the checksum files maven repositories publish next to artifacts, strongest first.
+var checksumAlgorithms = []string{"sha512", "sha256", "sha1"}
+
+// keyring is the project file holding the armored public keys artifact signatures are verified against.
+const keyring = "lyra.keys"
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, errors.New("unsupported checksum algorithm: " + algorithm)
+}
+
+// HashFile returns the hex encoded digest of a file.
+func HashFile(file string, algorithm string) (string, error) {
+	hash, err := newHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// VerifyFile fails with the expected and actual digest if a file does not match a known checksum.
+func VerifyFile(file string, name string, algorithm string, expected string) error {
+	actual, err := HashFile(file, algorithm)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s %s but got %s", name, algorithm, strings.ToLower(expected), actual)
+	}
+	return nil
+}
+
+func isChecksumFile(uri string) bool {
+	for _, algorithm := range checksumAlgorithms {
+		if strings.HasSuffix(uri, "."+algorithm) {
+			return true
+		}
+	}
+	return strings.HasSuffix(uri, ".md5") || strings.HasSuffix(uri, ".asc")
+}
+
+// verifyRepositoryChecksum checks a freshly downloaded file against the strongest checksum file the repository
+// publishes for it. Files without any checksum are accepted, but a checksum that can't be fetched for any other
+// reason than not being there fails the download.
+func verifyRepositoryChecksum(uri string, file string) error {
+	if isChecksumFile(uri) {
+		return nil
+	}
+
+	for _, algorithm := range checksumAlgorithms {
+		response, err := HttpGet(uri + "." + algorithm)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch the %s checksum of %s: %w", algorithm, uri, err)
+		}
+		data, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		// Some repositories append the file name after the digest
+		fields := strings.Fields(string(data))
+		if len(fields) == 0 {
+			continue
+		}
+		return VerifyFile(file, uri, algorithm, fields[0])
+	}
+	return nil
+}
+
+// verifyKnownChecksums checks the main file of an artifact against the digests its metadata provided.
+func (artifact Artifact) verifyKnownChecksums(uri string, file string) error {
+	if uri != artifact.Main {
+		return nil
+	}
+	if artifact.Sha256 != "" {
+		return VerifyFile(file, uri, "sha256", artifact.Sha256)
+	}
+	if artifact.Sha1 != "" {
+		return VerifyFile(file, uri, "sha1", artifact.Sha1)
+	}
+	return nil
+}
+
+// verifySignature checks the pgp signature of a downloaded file against the project keyring. It only runs when the
+// project has a keyring. Artifacts the repository has no signature for are accepted with a warning, but a signature
+// that can't be fetched for any other reason fails the verification.
+func verifySignature(uri string, file string) error {
+	if !fs.Exists(keyring) || isChecksumFile(uri) {
+		return nil
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil
+	}
+
+	signatureURL, err := url.Parse(uri + ".asc")
+	if err != nil {
+		return err
+	}
+	signature, err := Cache.Download(signatureURL, false)
+	if IsNotFound(err) {
+		println("warning: " + uri + " is not signed")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch the signature of %s: %w", uri, err)
+	}
+
+	keys, err := os.Open(keyring)
+	if err != nil {
+		return err
+	}
+	defer keys.Close()
+	entities, err := openpgp.ReadArmoredKeyRing(keys)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", keyring, err)
+	}
+
+	signed, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer signed.Close()
+	armored, err := os.Open(signature)
+	if err != nil {
+		return err
+	}
+	defer armored.Close()
+
+	if _, err := openpgp.CheckArmoredDetachedSignature(entities, signed, armored, nil); err != nil {
+		return fmt.Errorf("signature verification failed for %s: %s", uri, err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package lyra
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/mrnavastar/assist/fs"
+)
+
+// RepositoryCredentials authenticate requests to a private repository. Exactly one way of authenticating is used,
+// basic auth (Username and Password), a bearer Token, or a custom Header with a Value.
+type RepositoryCredentials struct {
+	// URL lets credentials apply to a url prefix that is not a repository of the project, like a plugin download
+	URL      string
+	Username string
+	Password string
+	Token    string
+	Header   string
+	Value    string
+}
+
+// credentialStore holds every section of the user credentials file by id. It is read once, lazily.
+var credentialStore struct {
+	mu sync.Mutex
+
+	loaded      bool
+	credentials map[string]RepositoryCredentials
+}
+
+// credentialsFile returns the path to the user credentials file. Credentials never live in lyra.json, so that
+// projects can be shared without leaking them.
+func credentialsFile() (string, error) {
+	config, err := GetConfig()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(config, "credentials"), nil
+}
+
+// parseCredentials reads an ini style file where every section is a credentials id:
+//
+//	[nexus]
+//	username = ci
+//	password = secret
+func parseCredentials(file string) (map[string]RepositoryCredentials, error) {
+	credentials := map[string]RepositoryCredentials{}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	id := ""
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			id = strings.TrimSpace(text[1 : len(text)-1])
+			credentials[id] = RepositoryCredentials{}
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("%s:%d: expected key = value inside a [id] section", file, line)
+		}
+		entry := credentials[id]
+		if err := entry.set(strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, line, err)
+		}
+		credentials[id] = entry
+	}
+	return credentials, scanner.Err()
+}
+
+func (credentials *RepositoryCredentials) set(key string, value string) error {
+	switch key {
+	case "url":
+		credentials.URL = value
+	case "username":
+		credentials.Username = value
+	case "password":
+		credentials.Password = value
+	case "token":
+		credentials.Token = value
+	case "header":
+		credentials.Header = value
+	case "value":
+		credentials.Value = value
+	default:
+		return errors.New("unknown key: " + key)
+	}
+	return nil
+}
+
+// credentialsEnv turns a credentials id into the prefix of its environment variables, nexus-releases becomes
+// LYRA_NEXUS_RELEASES_.
+func credentialsEnv(id string) string {
+	return "LYRA_" + strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, id) + "_"
+}
+
+// GetCredentials returns the credentials stored under an id. Environment variables (LYRA_<ID>_USERNAME, _PASSWORD,
+// _TOKEN, _HEADER and _VALUE) take precedence over the credentials file.
+func GetCredentials(id string) (RepositoryCredentials, bool, error) {
+	credentialStore.mu.Lock()
+	defer credentialStore.mu.Unlock()
+	if err := loadCredentials(); err != nil {
+		return RepositoryCredentials{}, false, err
+	}
+
+	credentials, found := credentialStore.credentials[id]
+	prefix := credentialsEnv(id)
+	for _, key := range []string{"username", "password", "token", "header", "value"} {
+		if value, ok := os.LookupEnv(prefix + strings.ToUpper(key)); ok {
+			credentials.set(key, value)
+			found = true
+		}
+	}
+	return credentials, found, nil
+}
+
+func loadCredentials() error {
+	if credentialStore.loaded {
+		return nil
+	}
+	credentialStore.loaded = true
+	credentialStore.credentials = map[string]RepositoryCredentials{}
+
+	file, err := credentialsFile()
+	if err != nil || !fs.Exists(file) {
+		return nil
+	}
+	credentials, err := parseCredentials(file)
+	if err != nil {
+		return err
+	}
+	credentialStore.credentials = credentials
+	return nil
+}
+
+// credentialsFor finds the credentials that apply to a url. Repositories of the project match by their url and use
+// the credentials named by Repository.Credentials, or their id. Sections of the credentials file with a url match
+// anything below it.
+func credentialsFor(uri string) (RepositoryCredentials, bool, error) {
+	longest := 0
+	id := ""
+	for _, repo := range GetCurrentProject().Repos() {
+		prefix := strings.TrimSuffix(repo.URL, "/") + "/"
+		if !strings.HasPrefix(uri, prefix) || len(prefix) <= longest {
+			continue
+		}
+		if name := repo.CredentialsId(); name != "" {
+			longest, id = len(prefix), name
+		}
+	}
+	if id != "" {
+		credentials, found, err := GetCredentials(id)
+		if found || err != nil {
+			return credentials, found, err
+		}
+	}
+
+	credentialStore.mu.Lock()
+	defer credentialStore.mu.Unlock()
+	if err := loadCredentials(); err != nil {
+		return RepositoryCredentials{}, false, err
+	}
+	longest = 0
+	var match RepositoryCredentials
+	for _, credentials := range credentialStore.credentials {
+		prefix := strings.TrimSuffix(credentials.URL, "/") + "/"
+		if credentials.URL != "" && strings.HasPrefix(uri, prefix) && len(prefix) > longest {
+			longest, match = len(prefix), credentials
+		}
+	}
+	return match, longest > 0, nil
+}
+
+// Apply adds the credentials to a request.
+func (credentials RepositoryCredentials) Apply(request *http.Request) error {
+	switch {
+	case credentials.Token != "":
+		request.Header.Set("Authorization", "Bearer "+credentials.Token)
+	case credentials.Header != "":
+		request.Header.Set(credentials.Header, credentials.Value)
+	case credentials.Username != "":
+		request.SetBasicAuth(credentials.Username, credentials.Password)
+	default:
+		return errors.New("credentials need a username, a token or a header")
+	}
+	return nil
+}
+
+// Authenticate adds the credentials for the request url, if there are any. Everything that talks to a repository
+// should send its requests through this.
+func Authenticate(request *http.Request) error {
+	uri := *request.URL
+	uri.User = nil
+	credentials, found, err := credentialsFor(uri.String())
+	if err != nil || !found {
+		return err
+	}
+	return credentials.Apply(request)
+}
+
+// Authenticate adds the credentials of this repository to a request, even if it is not part of the project yet.
+func (repo Repository) Authenticate(request *http.Request) error {
+	if id := repo.CredentialsId(); id != "" {
+		credentials, found, err := GetCredentials(id)
+		if err != nil {
+			return err
+		}
+		if found {
+			return credentials.Apply(request)
+		}
+		if repo.Credentials != "" {
+			return fmt.Errorf("no credentials named %s, add them to the credentials file or set %sUSERNAME and %sPASSWORD", id, credentialsEnv(id), credentialsEnv(id))
+		}
+	}
+	return Authenticate(request)
+}
@@ -2,24 +2,36 @@ package lyra
 
 import (
 	"errors"
+	"fmt"
 	"github.com/mrnavastar/assist/fs"
-	"github.com/mrnavastar/assist/web"
 	"github.com/urfave/cli/v2"
 	"net/url"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
 type Artifact struct {
-	Name         string     `json:",omitempty"`
-	Group        string     `json:",omitempty"`
-	Version      string     `json:",omitempty"`
-	Main         string     `json:",omitempty"`
-	Sources      string     `json:",omitempty"`
-	Docs         string     `json:",omitempty"`
+	Name    string `json:",omitempty"`
+	Group   string `json:",omitempty"`
+	Version string `json:",omitempty"`
+	// Packaging is the kind of file of the artifact, like pom, aar or zip, empty for jars
+	Packaging  string `json:",omitempty"`
+	Classifier string `json:",omitempty"`
+	Main       string `json:",omitempty"`
+	Sources    string `json:",omitempty"`
+	Docs       string `json:",omitempty"`
+	// Sha1 and Sha256 are digests of Main that are known up front, e.g. from launcher metadata
+	Sha1         string     `json:",omitempty"`
+	Sha256       string     `json:",omitempty"`
 	Include      bool       `json:",omitempty"`
+	Scope        string     `json:",omitempty"`
 	Dependencies []Artifact `json:",omitempty"`
+	// Platform is the coordinate of the platform that supplied the version, see Project.AddPlatform
+	Platform string `json:",omitempty"`
+	// Exclude lists group:name patterns that are left out of the graph below this artifact
+	Exclude []string `json:",omitempty"`
 }
 
 func init() {
@@ -31,33 +43,111 @@ func init() {
 			Name:   "get",
 			Args:   true,
 			Action: get,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "scope",
+					Usage: "one of compile, runtime, provided, test or processor",
+					Value: ScopeCompile,
+				},
+				&cli.BoolFlag{
+					Name:  "platform",
+					Usage: "import boms, dependencies added without a version then use the version they manage",
+				},
+				&cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "leave group:name patterns like commons-logging:* out of the dependencies of the artifact",
+				},
+			},
 			Subcommands: []*cli.Command{
 				{
 					Name:   "repo",
 					Args:   true,
 					Action: addRepo,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name: "id",
+						},
+						&cli.StringFlag{
+							Name:  "credentials",
+							Usage: "id of the credentials to use from the user credentials file, defaults to the repo id",
+						},
+						&cli.BoolFlag{
+							Name:  "releases",
+							Value: true,
+						},
+						&cli.BoolFlag{
+							Name:  "snapshots",
+							Value: true,
+						},
+						&cli.StringSliceFlag{
+							Name:  "include",
+							Usage: "only fetch groups matching this pattern from the repo, like net.fabricmc*",
+						},
+						&cli.StringSliceFlag{
+							Name:  "exclude",
+							Usage: "never fetch groups matching this pattern from the repo",
+						},
+						&cli.StringFlag{
+							Name:  "release-update",
+							Usage: "how often to check for new releases: always, daily, never or interval:<minutes>",
+						},
+						&cli.StringFlag{
+							Name:  "snapshot-update",
+							Usage: "how often to check for new snapshots: always, daily, never or interval:<minutes>",
+						},
+						&cli.StringFlag{
+							Name:  "before",
+							Usage: "url or id of the repo this one should be asked before",
+						},
+					},
 				},
 			},
 		},
 		{
-			Name: "tidy",
+			Name:   "remove",
+			Args:   true,
+			Action: remove,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "purge",
+					Usage: "also delete the cached files of every artifact that is no longer needed",
+				},
+				&cli.BoolFlag{
+					Name:  "platform",
+					Usage: "remove imported boms instead of dependencies",
+				},
+			},
+			Subcommands: []*cli.Command{
+				{
+					Name:   "repo",
+					Args:   true,
+					Action: removeRepo,
+				},
+			},
 		},
 	})
 }
 
 func resolveHttp(url *url.URL) (string, error) {
-	cache, err := GetCache()
+	localPath, err := Cache.Download(url, snapshotDue(url.String()))
 	if err != nil {
 		return "", err
 	}
+	return "file://" + localPath, nil
+}
 
-	file := filepath.Base(url.Path)
-	urlPath := strings.TrimSuffix(url.Path, file)
-	localPath := path.Join(cache, "libs", "http", url.Host, urlPath, file)
-	if err := web.Download(localPath, url.String()); err != nil {
-		return "", err
+// snapshotDue reports whether a snapshot file without a timestamp in its name should be downloaded again, following
+// the snapshot update policy of the repository it comes from.
+func snapshotDue(uri string) bool {
+	if !strings.Contains(uri, "-SNAPSHOT.") {
+		return false
 	}
-	return "file://" + localPath, nil
+	for _, repo := range GetCurrentProject().Repos() {
+		if strings.HasPrefix(uri, strings.TrimSuffix(repo.URL, "/")+"/") {
+			return repo.NeedsUpdate(uri, true)
+		}
+	}
+	return false
 }
 
 func (artifact Artifact) resolve(uri string) (string, error) {
@@ -89,26 +179,142 @@ func (artifact Artifact) resolve(uri string) (string, error) {
 	return filepath.Clean(localPath), nil
 }
 
+// Resolve returns the local path of the main artifact file, or an empty string if the artifact has no file of its own
+// (e.g. a pom that only aggregates dependencies).
 func (artifact Artifact) Resolve() (string, error) {
-	return artifact.resolve(artifact.Main)
+	if artifact.Main == "" {
+		return "", nil
+	}
+	return artifact.resolveLocked(artifact.Main)
 }
 
 func (artifact Artifact) ResolveSources() (string, error) {
 	if artifact.Sources == "" {
 		return "", nil
 	}
-	return artifact.resolve(artifact.Sources)
+	return artifact.resolveLocked(artifact.Sources)
 }
 
 func (artifact Artifact) ResolveDocs() (string, error) {
 	if artifact.Docs == "" {
 		return "", nil
 	}
-	return artifact.resolve(artifact.Docs)
+	return artifact.resolveLocked(artifact.Docs)
+}
+
+// resolveLocked resolves a file of the artifact and verifies it against lyra.lock. Files that are not locked yet are
+// first checked against the checksums known from metadata and the signature of the artifact. Local files are part of
+// the project and are used as they are, git dependencies are pinned by their commit instead.
+func (artifact Artifact) resolveLocked(uri string) (string, error) {
+	resolved, err := artifact.resolve(uri)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", artifact.Coordinate(), err)
+	}
+	if IsLocal(uri) || isGit(uri) {
+		return resolved, nil
+	}
+
+	lock := &GetCurrentProject().lock
+	if _, locked := lock.Get(uri); !locked {
+		if err := artifact.verifyKnownChecksums(uri, resolved); err != nil {
+			// Drop the bad file so that the next attempt downloads it again
+			Cache.Remove(uri)
+			return "", err
+		}
+		if err := verifySignature(uri, resolved); err != nil {
+			Cache.Remove(uri)
+			Cache.Remove(uri + ".asc")
+			return "", err
+		}
+	}
+	return resolved, lock.Verify(artifact, uri, resolved)
 }
 
 func (artifact Artifact) SameAs(other Artifact) bool {
-	return artifact.Name == other.Name && artifact.Group == other.Group
+	return artifact.Key() == other.Key()
+}
+
+// Flatten walks a dependency graph breadth first and returns every artifact in it once. When an artifact appears
+// more than once, the occurrence nearest to the root wins, and the first one declared wins between equals. The
+// returned artifacts carry their effective scope, which merges the scopes of every occurrence, see widerScope, except
+// for direct dependencies which keep the scope they are declared with. The processor path is mediated apart from the
+// classpaths, so an artifact can be returned once for each.
+// Substitutions of the current project replace artifacts before they are mediated, and exclusions drop everything
+// they match from the graph below the artifact that declares them.
+func Flatten(artifacts []Artifact) (flat []Artifact) {
+	type occurrence struct {
+		artifact Artifact
+		parent   int
+		declared string
+	}
+	type edge struct {
+		child    int
+		declared string
+	}
+
+	substitutions := GetCurrentProject().Substitutions()
+	seen := map[string]int{}
+	direct := map[int]bool{}
+	edges := map[int][]edge{}
+	var queue []occurrence
+	for _, artifact := range artifacts {
+		queue = append(queue, occurrence{artifact: artifact, parent: -1})
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		artifact, _ := substitute(current.artifact, substitutions)
+		artifact.Scope = artifact.EffectiveScope()
+		if current.parent >= 0 {
+			artifact.Scope = propagateScope(flat[current.parent].Scope, current.declared)
+		}
+
+		key := mediationKey(artifact, artifact.Scope)
+		index, ok := seen[key]
+		if !ok {
+			index = len(flat)
+			seen[key] = index
+			flat = append(flat, artifact)
+
+			for _, dependency := range artifact.Dependencies {
+				if dependency.Matches(artifact.Exclude) {
+					continue
+				}
+				dependency.Exclude = append(slices.Clip(artifact.Exclude), dependency.Exclude...)
+				queue = append(queue, occurrence{artifact: dependency, parent: index, declared: dependency.EffectiveScope()})
+			}
+		}
+		switch {
+		case current.parent >= 0:
+			edges[current.parent] = append(edges[current.parent], edge{child: index, declared: current.declared})
+		case ok:
+			flat[index].Scope = widerScope(flat[index].Scope, artifact.Scope)
+		default:
+			direct[index] = true
+		}
+	}
+
+	// A scope that widens changes the scopes of everything below, so they are propagated again until none changes.
+	// Scopes only ever widen, which makes this come to an end.
+	var changed []int
+	for index := range flat {
+		changed = append(changed, index)
+	}
+	for len(changed) > 0 {
+		parent := changed[0]
+		changed = changed[1:]
+		for _, edge := range edges[parent] {
+			if direct[edge.child] {
+				continue
+			}
+			scope := widerScope(flat[edge.child].Scope, propagateScope(flat[parent].Scope, edge.declared))
+			if scope != flat[edge.child].Scope {
+				flat[edge.child].Scope = scope
+				changed = append(changed, edge.child)
+			}
+		}
+	}
+	return flat
 }
 
 func get(ctx *cli.Context) error {
@@ -119,25 +325,82 @@ func get(ctx *cli.Context) error {
 	if !ctx.Args().Present() {
 		return errors.New("please specify at least one slug")
 	}
+	if ctx.Bool("platform") {
+		for _, slug := range ctx.Args().Slice() {
+			if err := GetCurrentProject().AddPlatform(Dependency.ParseMavenCoordinate(slug)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	scope, err := ParseScope(ctx.String("scope"))
+	if err != nil {
+		return err
+	}
+	var exclude []string
+	for _, pattern := range ctx.StringSlice("exclude") {
+		pattern, err := ValidatePattern(pattern)
+		if err != nil {
+			return err
+		}
+		exclude = append(exclude, pattern)
+	}
 
 	for _, slug := range ctx.Args().Slice() {
 		GetCurrentProject().Go(func() error {
-			for _, parser := range Dependency.parsers {
-				artifact, err := parser(slug)
-				if err != nil {
-					return err
-				}
-
-				if err := GetCurrentProject().AddDependency(artifact); err == nil {
-					return nil
-				}
-			}
-			return nil
+			return GetCurrentProject().GetExcluding(slug, scope, exclude)
 		})
 	}
 	return nil
 }
 
+// Get parses a slug with the registered parsers and adds the result to the project in the given scope. Every parser
+// gets a chance, the slug only fails if none of them could handle it.
+func (project *Project) Get(slug string, scope string) error {
+	return project.GetExcluding(slug, scope, nil)
+}
+
+// GetExcluding is Get with group:name patterns to leave out of the dependencies of the artifact, see
+// Artifact.Exclude. Without patterns the exclusions of a dependency that is already part of the project are kept.
+func (project *Project) GetExcluding(slug string, scope string, exclude []string) error {
+	keep := exclude == nil
+	if keep {
+		exclude = project.Exclusions(Dependency.ParseMavenCoordinate(slug))
+	}
+
+	return project.parse(slug, exclude, func(artifact Artifact) error {
+		if scope != ScopeCompile {
+			artifact.Scope = scope
+		}
+		artifact.Exclude = exclude
+		// Slugs that are no coordinate only name their artifact once parsed
+		if keep && exclude == nil {
+			artifact.Exclude = project.Exclusions(artifact)
+		}
+		return project.AddDependency(artifact)
+	})
+}
+
+// parse hands the artifact of every parser that could read a slug to add, until add accepts one of them. The exclude
+// patterns are passed on to the parsers.
+func (project *Project) parse(slug string, exclude []string, add func(artifact Artifact) error) error {
+	var errs []error
+	for _, parser := range Dependency.parsers {
+		artifact, err := parser(slug, exclude)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		err = add(artifact)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("failed to get %s: %w", slug, errors.Join(errs...))
+}
+
 func addRepo(ctx *cli.Context) error {
 	if !fs.Exists("lyra.json") {
 		return errors.New("no project in current directory")
@@ -146,64 +409,130 @@ func addRepo(ctx *cli.Context) error {
 		return errors.New("no repo provided")
 	}
 
-	parsed, err := url.Parse(ctx.Args().First())
+	repo := Repository{
+		Id:          ctx.String("id"),
+		URL:         ctx.Args().First(),
+		Credentials: ctx.String("credentials"),
+	}
+	releases, err := repositoryPolicy(ctx.Bool("releases"), ctx.String("release-update"))
 	if err != nil {
 		return err
 	}
-	return GetCurrentProject().AddRepo(*parsed)
+	snapshots, err := repositoryPolicy(ctx.Bool("snapshots"), ctx.String("snapshot-update"))
+	if err != nil {
+		return err
+	}
+	repo.Releases = releases
+	repo.Snapshots = snapshots
+	repo.Include = ctx.StringSlice("include")
+	repo.Exclude = ctx.StringSlice("exclude")
+	for _, pattern := range append(slices.Clone(repo.Include), repo.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid group pattern %s: %s", pattern, err)
+		}
+	}
+
+	project := GetCurrentProject()
+	if err := project.AddRepo(repo); err != nil {
+		return err
+	}
+	if before := ctx.String("before"); before != "" {
+		return project.MoveRepo(repo.URL, before)
+	}
+	return nil
 }
 
-/*func FindModuleDependencies(project *Project, name string) (dependencies []Dependency, err error) {
-	var classes []string
-	if err := filepath.WalkDir(path.Join("build/output", name), func(path string, d fs.DirEntry, err error) error {
-		if d.IsDir() || !strings.HasSuffix(path, ".class") {
-			return nil
+// repositoryPolicy builds the policy for the get repo flags, nil if everything is left at the defaults.
+func repositoryPolicy(enabled bool, update string) (*RepositoryPolicy, error) {
+	if update != "" {
+		if _, err := ParseUpdatePolicy(update); err != nil {
+			return nil, err
 		}
+	}
+	if enabled && update == "" {
+		return nil, nil
+	}
+	return &RepositoryPolicy{Disabled: !enabled, Update: update}, nil
+}
 
-		member, err := babe.JarMemberFromFile(path)
-		if err != nil {
-			return err
-		}
+func remove(ctx *cli.Context) error {
+	if !fs.Exists("lyra.json") {
+		return errors.New("no project in current directory")
+	}
+	if !ctx.Args().Present() {
+		return errors.New("please specify at least one group:name")
+	}
 
-		class, err := member.GetAsClass()
-		if err != nil {
-			return err
+	project := GetCurrentProject()
+	if ctx.Bool("platform") {
+		for _, slug := range ctx.Args().Slice() {
+			if !project.RemovePlatform(Dependency.ParseMavenCoordinate(slug)) {
+				return fmt.Errorf("%s is not a platform of this project", slug)
+			}
+		}
+		return nil
+	}
+	var removed []Artifact
+	for _, slug := range ctx.Args().Slice() {
+		artifact := Dependency.ParseMavenCoordinate(slug)
+		if artifact.Name == "" {
+			return errors.New("not a valid group:name: " + slug)
 		}
 
-		// Add class imports as dependencies unless they start with java, as those are always provided
-		for _, constant := range class.ConstantPool {
-			if info, ok := constant.(*babe.ClassInfo); ok {
-				dep := class.GetConstant(info.NameIndex).(babe.Utf8Info).String()
-				if !strings.HasPrefix(dep, "java") {
-					classes = append(classes, dep)
+		index := slices.IndexFunc(project.Dependencies(), artifact.SameAs)
+		if index == -1 {
+			for _, direct := range project.Dependencies() {
+				if slices.ContainsFunc(Flatten([]Artifact{direct})[1:], artifact.SameAs) {
+					return fmt.Errorf("%s is not a direct dependency, it is required by %s:%s", slug, direct.Group, direct.Name)
 				}
 			}
+			return fmt.Errorf("%s is not a dependency of this project", slug)
 		}
-		return nil
-	}); err != nil {
-		return dependencies, err
+		removed = append(removed, project.Dependencies()[index])
+		project.RemoveDependency(artifact)
 	}
+	return project.releaseUnreachable(removed, ctx.Bool("purge"))
+}
 
-	if err := filepath.WalkDir(path.Join(project.Home, "libs"), func(path string, d fs.DirEntry, err error) error {
-		if d.IsDir() || !strings.HasSuffix(path, ".jar") {
-			return nil
+// releaseUnreachable drops the files of artifacts that left the project from lyra.lock, and from the cache with purge.
+// Anything still reachable from the remaining dependencies is kept around.
+func (project *Project) releaseUnreachable(artifacts []Artifact, purge bool) error {
+	reachable := map[string]bool{}
+	for _, artifact := range Flatten(project.Dependencies()) {
+		for _, uri := range []string{artifact.Main, artifact.Sources, artifact.Docs} {
+			reachable[uri] = true
 		}
+	}
+
+	for _, artifact := range Flatten(artifacts) {
+		for _, uri := range []string{artifact.Main, artifact.Sources, artifact.Docs} {
+			if uri == "" || reachable[uri] {
+				continue
+			}
+			project.lock.Remove(uri)
 
-		return babe.ForJarMember(path, func(member *babe.JarMember) error {
-			for _, class := range classes {
-				if class == strings.TrimSuffix(strings.ReplaceAll(member.Name, "/", "."), ".class") {
-					dep, err := project.GetDependencyByPath(path)
-					if err != nil {
-						return err
-					}
-					dependencies = append(dependencies, *dep)
-					return nil
+			if purge {
+				if err := Cache.Remove(uri); err != nil {
+					return err
 				}
 			}
-			return nil
-		})
-	}); err != nil {
-		return dependencies, err
+		}
+	}
+	return nil
+}
+
+func removeRepo(ctx *cli.Context) error {
+	if !fs.Exists("lyra.json") {
+		return errors.New("no project in current directory")
 	}
-	return dependencies, nil
-}*/
+	if ctx.Args().Len() == 0 {
+		return errors.New("no repo provided")
+	}
+
+	for _, repo := range ctx.Args().Slice() {
+		if !GetCurrentProject().RemoveRepo(repo) {
+			return errors.New("no repo with url or id: " + repo)
+		}
+	}
+	return nil
+}
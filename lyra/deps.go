@@ -0,0 +1,338 @@
+package lyra
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mrnavastar/assist/fs"
+	"github.com/urfave/cli/v2"
+)
+
+// dependencyNode is one occurrence of an artifact in the dependency graph.
+type dependencyNode struct {
+	artifact Artifact
+	children []*dependencyNode
+	// original is the artifact the graph declares, it differs from artifact when a substitution replaced it
+	original    Artifact
+	substituted bool
+	// excluding are the exclusions declared by a direct dependency
+	excluding []string
+	// scope is the effective scope of this occurrence, see propagateScope
+	scope string
+	// selected is true for the occurrence that won mediation, see Flatten
+	selected bool
+	// winner is the version that mediation picked for this artifact
+	winner string
+}
+
+// treeEntry is the machine-readable form of a dependencyNode.
+type treeEntry struct {
+	Group        string
+	Name         string
+	Version      string
+	Scope        string
+	Platform     string      `json:",omitempty"`
+	Replaces     string      `json:",omitempty"`
+	Exclude      []string    `json:",omitempty"`
+	Selected     string      `json:",omitempty"`
+	Omitted      bool        `json:",omitempty"`
+	Dependencies []treeEntry `json:",omitempty"`
+}
+
+var outputFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "json",
+		Usage: "print the graph as json",
+	},
+	&cli.BoolFlag{
+		Name:  "dot",
+		Usage: "print the graph in the graphviz dot format",
+	},
+}
+
+func init() {
+	Command.Register(&cli.Command{
+		Name: "deps",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "tree",
+				Args:   false,
+				Action: dependencyTree,
+				Flags:  outputFlags,
+			},
+			{
+				Name:   "why",
+				Args:   true,
+				Action: dependencyWhy,
+				Flags:  outputFlags,
+			},
+			{
+				Name:   "outdated",
+				Args:   false,
+				Action: dependencyOutdated,
+			},
+			{
+				Name:   "upgrade",
+				Args:   true,
+				Action: dependencyUpgrade,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "minor",
+						Usage: "only upgrade within the current major version",
+					},
+					&cli.BoolFlag{
+						Name:  "patch",
+						Usage: "only upgrade within the current minor version",
+					},
+				},
+			},
+		},
+	})
+}
+
+func buildDependencyTree(artifacts []Artifact) (roots []*dependencyNode) {
+	substitutions := GetCurrentProject().Substitutions()
+	var build func(artifact Artifact, scope string) *dependencyNode
+	build = func(artifact Artifact, scope string) *dependencyNode {
+		node := &dependencyNode{original: artifact, scope: scope}
+		node.artifact, node.substituted = substitute(artifact, substitutions)
+		for _, dependency := range node.artifact.Dependencies {
+			if dependency.Matches(node.artifact.Exclude) {
+				continue
+			}
+			dependency.Exclude = append(slices.Clip(node.artifact.Exclude), dependency.Exclude...)
+			node.children = append(node.children, build(dependency, propagateScope(scope, dependency.EffectiveScope())))
+		}
+		return node
+	}
+	for _, artifact := range artifacts {
+		root := build(artifact, artifact.EffectiveScope())
+		root.excluding = artifact.Exclude
+		roots = append(roots, root)
+	}
+
+	// Walk the graph in the same order as Flatten so the same occurrences win
+	winners := map[string]*dependencyNode{}
+	var all []*dependencyNode
+	queue := append([]*dependencyNode{}, roots...)
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		all = append(all, node)
+
+		key := mediationKey(node.artifact, node.scope)
+		if _, ok := winners[key]; !ok {
+			winners[key] = node
+			node.selected = true
+		}
+		queue = append(queue, node.children...)
+	}
+	for _, node := range all {
+		node.winner = winners[mediationKey(node.artifact, node.scope)].artifact.Version
+	}
+	return roots
+}
+
+func (node *dependencyNode) coordinate() string {
+	return node.artifact.Coordinate()
+}
+
+// label is the coordinate of this occurrence along with what a substitution replaced it with.
+func (node *dependencyNode) label() string {
+	if !node.substituted {
+		return node.coordinate()
+	}
+	if node.original.SameAs(node.artifact) {
+		return node.original.Coordinate() + " -> " + node.artifact.Version
+	}
+	return node.original.Coordinate() + " -> " + node.coordinate()
+}
+
+// describe explains what mediation did with this occurrence.
+func (node *dependencyNode) describe() string {
+	if node.selected {
+		return ""
+	}
+	if node.winner != node.artifact.Version {
+		return fmt.Sprintf(" (omitted for conflict with %s)", node.winner)
+	}
+	return " (omitted for duplicate)"
+}
+
+func (node *dependencyNode) entry() treeEntry {
+	entry := treeEntry{
+		Group:    node.artifact.Group,
+		Name:     node.artifact.Name,
+		Version:  node.artifact.Version,
+		Scope:    node.scope,
+		Platform: node.artifact.Platform,
+		Exclude:  node.excluding,
+		Omitted:  !node.selected,
+	}
+	if node.substituted {
+		entry.Replaces = node.original.Coordinate()
+	}
+	if node.winner != node.artifact.Version {
+		entry.Selected = node.winner
+	}
+	for _, child := range node.children {
+		entry.Dependencies = append(entry.Dependencies, child.entry())
+	}
+	return entry
+}
+
+func printTree(nodes []*dependencyNode, prefix string) {
+	for i, node := range nodes {
+		branch, indent := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, indent = "└── ", "    "
+		}
+		scope := ""
+		if node.scope != ScopeCompile {
+			scope = " [" + node.scope + "]"
+		}
+		platform := ""
+		if node.artifact.Platform != "" {
+			platform = " (managed by " + node.artifact.Platform + ")"
+		}
+		excluding := ""
+		if len(node.excluding) > 0 {
+			excluding = " (excluding " + strings.Join(node.excluding, ", ") + ")"
+		}
+		fmt.Println(prefix + branch + node.label() + scope + platform + excluding + node.describe())
+		// The dependencies of a duplicate are already listed below the occurrence that was selected
+		if node.selected || node.winner != node.artifact.Version {
+			printTree(node.children, prefix+indent)
+		}
+	}
+}
+
+func printJson(value any) error {
+	data, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// printDot writes every edge between the given paths, paths always start at a direct dependency of the project.
+func printDot(paths [][]*dependencyNode) {
+	fmt.Println("digraph dependencies {")
+	edges := map[string]bool{}
+	for _, path := range paths {
+		parent := "project"
+		for _, node := range path {
+			edge := fmt.Sprintf("    %q -> %q", parent, node.coordinate())
+			if !node.selected {
+				edge += " [style=dashed]"
+			}
+			if !edges[edge] {
+				edges[edge] = true
+				fmt.Println(edge + ";")
+			}
+			parent = node.coordinate()
+		}
+	}
+	fmt.Println("}")
+}
+
+// findPaths returns every path from a direct dependency to an occurrence of the artifact.
+func findPaths(nodes []*dependencyNode, artifact Artifact, path []*dependencyNode) (paths [][]*dependencyNode) {
+	for _, node := range nodes {
+		current := append(append([]*dependencyNode{}, path...), node)
+		if node.artifact.SameAs(artifact) || (node.substituted && node.original.SameAs(artifact)) {
+			paths = append(paths, current)
+		}
+		paths = append(paths, findPaths(node.children, artifact, current)...)
+	}
+	return paths
+}
+
+// allPaths returns every path from a direct dependency to a leaf.
+func allPaths(nodes []*dependencyNode, path []*dependencyNode) (paths [][]*dependencyNode) {
+	for _, node := range nodes {
+		current := append(append([]*dependencyNode{}, path...), node)
+		if len(node.children) == 0 {
+			paths = append(paths, current)
+			continue
+		}
+		paths = append(paths, allPaths(node.children, current)...)
+	}
+	return paths
+}
+
+func dependencyTree(ctx *cli.Context) error {
+	if !fs.Exists("lyra.json") {
+		return errors.New("no project in current directory")
+	}
+	project := GetCurrentProject()
+	roots := buildDependencyTree(project.Dependencies())
+
+	switch {
+	case ctx.Bool("json"):
+		var entries []treeEntry
+		for _, root := range roots {
+			entries = append(entries, root.entry())
+		}
+		return printJson(entries)
+	case ctx.Bool("dot"):
+		printDot(allPaths(roots, nil))
+		return nil
+	}
+
+	name := project.Name()
+	if name == "" {
+		name = "project"
+	}
+	fmt.Println(name)
+	printTree(roots, "")
+	return nil
+}
+
+func dependencyWhy(ctx *cli.Context) error {
+	if !fs.Exists("lyra.json") {
+		return errors.New("no project in current directory")
+	}
+	if ctx.Args().Len() == 0 {
+		return errors.New("no group:name provided")
+	}
+	artifact := Dependency.ParseMavenCoordinate(ctx.Args().First())
+	if artifact.Name == "" {
+		return errors.New("not a valid group:name: " + ctx.Args().First())
+	}
+
+	paths := findPaths(buildDependencyTree(GetCurrentProject().Dependencies()), artifact, nil)
+	if len(paths) == 0 {
+		return fmt.Errorf("%s:%s is not a dependency of this project", artifact.Group, artifact.Name)
+	}
+
+	switch {
+	case ctx.Bool("json"):
+		var entries [][]string
+		for _, path := range paths {
+			var coordinates []string
+			for _, node := range path {
+				coordinates = append(coordinates, node.coordinate())
+			}
+			entries = append(entries, coordinates)
+		}
+		return printJson(entries)
+	case ctx.Bool("dot"):
+		printDot(paths)
+		return nil
+	}
+
+	for _, path := range paths {
+		var coordinates []string
+		for _, node := range path {
+			coordinates = append(coordinates, node.label())
+		}
+		last := path[len(path)-1]
+		fmt.Println("project -> " + strings.Join(coordinates, " -> ") + last.describe())
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package lyra
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mrnavastar/assist/fs"
+)
+
+// gitSchemes are the transports git dependencies can be fetched with, as git+<transport>://host/repo.git.
+var gitSchemes = []string{"git+https", "git+http", "git+ssh", "git+file"}
+
+var commitPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// gitMu serializes git commands, clones of the same repository share a mirror.
+var gitMu sync.Mutex
+
+func init() {
+	Dependency.RegisterParser(gitParser)
+	for _, scheme := range gitSchemes {
+		Dependency.RegisterResolver(scheme, resolveGit)
+	}
+}
+
+// isGit reports whether a uri is a git dependency. These are pinned by their commit instead of lyra.lock.
+func isGit(uri string) bool {
+	return strings.HasPrefix(uri, "git+")
+}
+
+// gitLocation splits a git dependency into the repository to clone, the ref to check out and the path inside the
+// repository. The fragment is ref[:path], a path to a jar is used as is while a directory is built as a lyra project.
+func gitLocation(uri *url.URL) (remote string, ref string, file string) {
+	repo := *uri
+	repo.Scheme = strings.TrimPrefix(repo.Scheme, "git+")
+	repo.Fragment = ""
+	repo.RawFragment = ""
+	ref, file, _ = strings.Cut(uri.Fragment, ":")
+	return repo.String(), ref, strings.Trim(file, "/")
+}
+
+// gitURI formats a git dependency pinned to a commit.
+func gitURI(uri *url.URL, commit string, file string) string {
+	pinned := *uri
+	pinned.Fragment = commit
+	if file != "" {
+		pinned.Fragment += ":" + file
+	}
+	pinned.RawFragment = ""
+	return pinned.String()
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitDir returns where the clones of a repository are kept in the cache.
+func gitDir(remote string) (string, error) {
+	cache, err := GetCache()
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(remote))
+	return path.Join(cache, "git", hex.EncodeToString(digest[:8])), nil
+}
+
+// gitCheckout makes sure a commit of a repository is checked out in the cache and returns the checkout along with
+// the full commit hash. Every repository is cloned once as a bare mirror, with one worktree per commit that is used.
+// Anything but a full commit hash is looked up on the remote, unless running offline.
+func gitCheckout(remote string, ref string) (dir string, commit string, err error) {
+	gitMu.Lock()
+	defer gitMu.Unlock()
+
+	base, err := gitDir(remote)
+	if err != nil {
+		return "", "", err
+	}
+	mirror := path.Join(base, "mirror.git")
+	if !fs.Exists(mirror) {
+		if IsOffline() {
+			return "", "", fmt.Errorf("%s has not been cloned yet and lyra is offline", remote)
+		}
+		if err := os.MkdirAll(base, os.ModePerm); err != nil {
+			return "", "", err
+		}
+		if _, err := runGit(base, "clone", "--bare", "--quiet", remote, "mirror.git"); err != nil {
+			return "", "", err
+		}
+	}
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+	commit, err = runGit(mirror, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil || !commitPattern.MatchString(ref) {
+		if IsOffline() {
+			if err != nil {
+				return "", "", fmt.Errorf("%s is not known for %s and lyra is offline", ref, remote)
+			}
+		} else {
+			if _, err := runGit(mirror, "fetch", "--quiet", "--force", "--tags", "origin", "+refs/heads/*:refs/heads/*"); err != nil {
+				return "", "", err
+			}
+			if commit, err = runGit(mirror, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+				return "", "", fmt.Errorf("%s has no branch, tag or commit named %s", remote, ref)
+			}
+		}
+	}
+
+	dir = path.Join(base, commit)
+	if !fs.Exists(dir) {
+		if _, err := runGit(mirror, "worktree", "add", "--detach", "--quiet", dir, commit); err != nil {
+			return "", "", err
+		}
+	}
+	return dir, commit, nil
+}
+
+// gitParser handles git+<transport>://host/repo.git#ref[:path]. The ref is resolved to a commit right away, and the
+// commit is what ends up in lyra.json so that every build uses the same sources.
+func gitParser(slug string) (Artifact, error) {
+	if !isGit(slug) {
+		return Artifact{}, errors.New("not a git repository: " + slug)
+	}
+	uri, err := url.Parse(slug)
+	if err != nil {
+		return Artifact{}, err
+	}
+	remote, ref, file := gitLocation(uri)
+	checkout, commit, err := gitCheckout(remote, ref)
+	if err != nil {
+		return Artifact{}, err
+	}
+
+	artifact := Artifact{
+		Group:   uri.Host,
+		Name:    strings.TrimSuffix(path.Base(uri.Path), ".git"),
+		Version: ref,
+		Main:    gitURI(uri, commit, file),
+	}
+	if artifact.Group == "" {
+		artifact.Group = "git"
+	}
+	if ref == "" || ref == commit {
+		artifact.Version = commit[:12]
+	}
+
+	dir := filepath.Join(checkout, filepath.FromSlash(file))
+	info, err := os.Stat(dir)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("%s has no %s at %s", remote, file, artifact.Version)
+	}
+	if !info.IsDir() || !fs.Exists(filepath.Join(dir, "lyra.json")) {
+		if file != "" {
+			artifact.Name = strings.TrimSuffix(path.Base(file), path.Ext(file))
+			if groups := versionSuffix.FindStringSubmatch(artifact.Name); groups != nil {
+				artifact.Name = groups[1]
+			}
+		}
+		if info.IsDir() {
+			artifact.Packaging = "dir"
+		} else if path.Ext(file) != ".jar" {
+			artifact.Packaging = strings.TrimPrefix(path.Ext(file), ".")
+		}
+		return artifact, nil
+	}
+
+	proxy, err := readProject(dir)
+	if err != nil {
+		return Artifact{}, err
+	}
+	artifact.Name = proxy.Name
+	if proxy.Group != "" {
+		artifact.Group = proxy.Group
+	}
+
+	// Relative files of the project are taken from the same commit
+	for _, dependency := range proxy.Artifacts {
+		rebased, err := rebaseLocal(dependency, func(local *url.URL) (string, error) {
+			inside := path.Join(file, filepath.ToSlash(localPath(local)))
+			if inside == ".." || strings.HasPrefix(inside, "../") {
+				return "", fmt.Errorf("%s depends on %s, which is outside of the repository", slug, local)
+			}
+			if local.Fragment != "" {
+				return "", fmt.Errorf("%s depends on module %s of a project, which git dependencies do not support", slug, local.Fragment)
+			}
+			return gitURI(uri, commit, inside), nil
+		})
+		if err != nil {
+			return Artifact{}, err
+		}
+		artifact.Dependencies = append(artifact.Dependencies, rebased)
+	}
+	return artifact, nil
+}
+
+// resolveGit checks out the pinned commit and returns the file it points to, lyra projects are built first.
+func resolveGit(uri *url.URL) (string, error) {
+	remote, commit, file := gitLocation(uri)
+	checkout, _, err := gitCheckout(remote, commit)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(checkout, filepath.FromSlash(file))
+	if info, err := os.Stat(target); err == nil && info.IsDir() && fs.Exists(filepath.Join(target, "lyra.json")) {
+		if err := buildLocalProject(target); err != nil {
+			return "", err
+		}
+		target = filepath.Join(target, "build", "jar", "main.jar")
+	}
+	if !fs.Exists(target) {
+		return "", fmt.Errorf("%s has no %s at %s", remote, strings.TrimPrefix(target, checkout+string(filepath.Separator)), commit)
+	}
+	return localURI("file", target), nil
+}
@@ -0,0 +1,197 @@
+package lyra
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/mrnavastar/assist/fs"
+)
+
+var httpClient struct {
+	once sync.Once
+
+	client *http.Client
+	err    error
+}
+
+// mirrorTransport sends requests to their mirror and authenticates them. Redirects are followed as they are, a mirror
+// that redirects to another host must not be sent to a mirror again.
+type mirrorTransport struct {
+	base http.RoundTripper
+}
+
+func (transport mirrorTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it was given
+	request = request.Clone(request.Context())
+
+	// The client sets Response on the requests it makes to follow a redirect
+	location, mirror := request.URL, (*Mirror)(nil)
+	if request.Response == nil {
+		location, mirror = settings.MirrorFor(request.URL)
+	}
+	if mirror == nil {
+		if request.Header.Get("Authorization") == "" {
+			if err := Authenticate(request); err != nil {
+				return nil, err
+			}
+		}
+		return transport.base.RoundTrip(request)
+	}
+
+	request.URL = location
+	request.Host = location.Host
+	// Credentials of the original repository mean nothing to the mirror
+	request.Header.Del("Authorization")
+	credentials, found, err := GetCredentials(mirror.Id)
+	if mirror.Id == "" {
+		credentials, found, err = credentialsFor(location.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if err := credentials.Apply(request); err != nil {
+			return nil, err
+		}
+	}
+	return transport.base.RoundTrip(request)
+}
+
+// proxyFor picks the proxy from the user settings, falling back to the environment.
+func proxyFor(request *http.Request) (*url.URL, error) {
+	proxy := settings.Proxy
+	if proxy == nil {
+		return http.ProxyFromEnvironment(request)
+	}
+
+	host := request.URL.Hostname()
+	for _, pattern := range proxy.NoProxy {
+		if host == pattern || (strings.HasPrefix(pattern, ".") && strings.HasSuffix(host, pattern)) {
+			return nil, nil
+		}
+	}
+	location := proxy.HTTP
+	if request.URL.Scheme == "https" && proxy.HTTPS != "" {
+		location = proxy.HTTPS
+	}
+	if location == "" {
+		return nil, nil
+	}
+	return url.Parse(location)
+}
+
+func newHttpClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFor
+
+	if settings.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(settings.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca bundle: %s", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificates found in ca bundle " + settings.CABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: mirrorTransport{base: transport}}, nil
+}
+
+// HttpClient returns the client every network request of lyra and its plugins should go through. It applies the
+// mirrors, proxy and certificate authorities from the user settings, and the credentials of the repository.
+func HttpClient() (*http.Client, error) {
+	httpClient.once.Do(func() {
+		httpClient.client, httpClient.err = newHttpClient()
+	})
+	return httpClient.client, httpClient.err
+}
+
+// HttpStatusError is returned by HttpGet when the server answered with anything but 200.
+type HttpStatusError struct {
+	URL        string
+	Status     string
+	StatusCode int
+}
+
+func (err *HttpStatusError) Error() string {
+	if err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden {
+		return fmt.Sprintf("failed to download: %s bad status: %s, check the credentials for this repository", err.URL, err.Status)
+	}
+	return fmt.Sprintf("failed to download: %s bad status: %s", err.URL, err.Status)
+}
+
+// IsNotFound reports whether an error of HttpGet means the server does not have the file.
+func IsNotFound(err error) bool {
+	var status *HttpStatusError
+	return errors.As(err, &status) && status.StatusCode == http.StatusNotFound
+}
+
+// HttpGet makes a get request with the shared client, responses other than 200 are turned into a HttpStatusError.
+func HttpGet(uri string) (*http.Response, error) {
+	client, err := HttpClient()
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, &HttpStatusError{URL: uri, Status: response.Status, StatusCode: response.StatusCode}
+	}
+	return response, nil
+}
+
+// GetJson fetches a url and decodes the json response into value.
+func GetJson(uri string, value any) error {
+	response, err := HttpGet(uri)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// DownloadFile downloads a url to a file, unless the file already exists.
+func DownloadFile(file string, uri string) error {
+	if fs.Exists(file) {
+		return nil
+	}
+	if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+
+	response, err := HttpGet(uri)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// Download next to the file so that an interrupted download is never mistaken for a finished one
+	temp, err := os.CreateTemp(path.Dir(file), path.Base(file)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = io.Copy(temp, response.Body)
+	temp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file)
+}
@@ -0,0 +1,421 @@
+package lyra
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	fss "github.com/mrnavastar/assist/fs"
+	"github.com/mrnavastar/babe/babe"
+)
+
+// compileStates is where the state of every incremental compilation is kept, see compileState.
+const compileStates = "build/compile"
+
+// compileStateFile returns the state file of the sources or the tests of a module.
+func compileStateFile(module string, tests bool) string {
+	if tests {
+		return path.Join(compileStates, "tests", module+".json")
+	}
+	return path.Join(compileStates, "sources", module+".json")
+}
+
+// compileState is what the last compilation of a source directory produced. Sources are keyed by their path relative
+// to the source directory.
+type compileState struct {
+	// Classpath sums up the classpath the sources were compiled against, any change to it compiles everything again
+	Classpath string
+	Sources   map[string]*compiledSource
+}
+
+// compiledSource is a single java file along with the classes compiled from it, and the classes of the module those
+// reference. Constants are the values of the constant fields of its classes, keyed by class.field.
+type compiledSource struct {
+	Hash      string
+	Classes   []string
+	Uses      []string          `json:",omitempty"`
+	Constants map[string]string `json:",omitempty"`
+}
+
+// signatureClasses matches the class names in field and method descriptors and generic signatures.
+var signatureClasses = regexp.MustCompile(`L([^;<>.:()\[]+)[;<.]`)
+
+// compileIncremental compiles the java files below a source directory, and keeps track of each of them in a state
+// file. Only the sources that changed since the last compilation are compiled again, along with every source that
+// depends on them. Class files of deleted sources are removed. It reports whether javac ran at all.
+//
+// javac copies constants into the classes that use them, and annotation processors write classes no source owns, so
+// neither can be traced back. Everything is compiled again when a constant changed or went away, or when the classes
+// written by annotation processors did.
+func compileIncremental(sourceDir string, stateFile string, options JavaCompileOptions) (bool, error) {
+	sources, err := javaSources(sourceDir)
+	if err != nil {
+		return false, err
+	}
+	hashes := map[string]string{}
+	for _, source := range sources {
+		relative, err := filepath.Rel(sourceDir, source)
+		if err != nil {
+			return false, err
+		}
+		if hashes[filepath.ToSlash(relative)], err = Sha256Sum(source); err != nil {
+			return false, err
+		}
+	}
+	classpath := classpathDigest(append(slices.Clone(options.Classpath), options.ProcessorPath...))
+
+	previous, ok := readCompileState(stateFile)
+	changed := changedSources(previous, hashes)
+	full := !ok || previous.Classpath != classpath || !fss.Exists(options.Output)
+	if !full && len(changed) == 0 {
+		return false, nil
+	}
+	// What annotation processors generated from a deleted source can't be told apart from the rest
+	processors := len(options.ProcessorPath) > 0
+	if processors && slices.ContainsFunc(changed, func(name string) bool { return hashes[name] == "" }) {
+		full = true
+	}
+
+	// Forget the state until the compilation succeeded, so a failed one is followed by a full compilation
+	if err := os.RemoveAll(stateFile); err != nil {
+		return false, err
+	}
+
+	if !full {
+		var generated map[string]string
+		if processors {
+			if generated, err = generatedClasses(options.Output, previous); err != nil {
+				return false, err
+			}
+		}
+
+		state := compileState{Classpath: classpath, Sources: map[string]*compiledSource{}}
+		var compile []string
+		affected := dependentSources(previous, changed)
+		for name, source := range previous.Sources {
+			if affected[name] {
+				for _, class := range source.Classes {
+					if err := os.Remove(path.Join(options.Output, class+".class")); err != nil && !os.IsNotExist(err) {
+						return false, err
+					}
+				}
+				continue
+			}
+			state.Sources[name] = source
+		}
+		for name := range affected {
+			if _, ok := hashes[name]; ok {
+				compile = append(compile, path.Join(sourceDir, name))
+			}
+		}
+		slices.Sort(compile)
+
+		if len(compile) > 0 {
+			// The classes of the unchanged sources are not compiled again, but still have to be found
+			incremental := options
+			incremental.Classpath = append([]string{options.Output}, options.Classpath...)
+			incremental.Sources = compile
+			if err := Java.Compile(incremental); err != nil {
+				return false, err
+			}
+		}
+		if err := state.track(options.Output, hashes); err != nil {
+			return false, err
+		}
+
+		same := maps.Equal(previous.constants(), state.constants())
+		if same && processors {
+			now, err := generatedClasses(options.Output, state)
+			if err != nil {
+				return false, err
+			}
+			same = maps.Equal(generated, now)
+		}
+		if same {
+			return true, state.write(stateFile)
+		}
+	}
+
+	if err := os.RemoveAll(options.Output); err != nil {
+		return false, err
+	}
+	if len(sources) > 0 {
+		options.Sources = sources
+		if err := Java.Compile(options); err != nil {
+			return false, err
+		}
+	}
+	state := compileState{Classpath: classpath, Sources: map[string]*compiledSource{}}
+	if err := state.track(options.Output, hashes); err != nil {
+		return false, err
+	}
+	return true, state.write(stateFile)
+}
+
+// changedSources returns the sources that were added, changed or deleted since the last compilation.
+func changedSources(previous compileState, hashes map[string]string) (changed []string) {
+	for name, hash := range hashes {
+		if source, ok := previous.Sources[name]; !ok || source.Hash != hash {
+			changed = append(changed, name)
+		}
+	}
+	for name := range previous.Sources {
+		if _, ok := hashes[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// dependentSources returns the changed sources along with every source that uses one of their classes, directly or
+// through other sources.
+func dependentSources(previous compileState, changed []string) map[string]bool {
+	owners := map[string]string{}
+	for name, source := range previous.Sources {
+		for _, class := range source.Classes {
+			owners[class] = name
+		}
+	}
+	dependents := map[string][]string{}
+	for name, source := range previous.Sources {
+		for _, class := range source.Uses {
+			if owner, ok := owners[class]; ok && owner != name {
+				dependents[owner] = append(dependents[owner], name)
+			}
+		}
+	}
+
+	affected := map[string]bool{}
+	queue := slices.Clone(changed)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if affected[name] {
+			continue
+		}
+		affected[name] = true
+		queue = append(queue, dependents[name]...)
+	}
+	return affected
+}
+
+// track records the class files in the output that no source owns yet as compiled from their source, along with
+// the classes of the module they reference.
+func (state *compileState) track(output string, hashes map[string]string) error {
+	owned := map[string]bool{}
+	for name, source := range state.Sources {
+		source.Hash = hashes[name]
+		for _, class := range source.Classes {
+			owned[class] = true
+		}
+	}
+	for name, hash := range hashes {
+		if _, ok := state.Sources[name]; !ok {
+			state.Sources[name] = &compiledSource{Hash: hash}
+		}
+	}
+
+	if !fss.Exists(output) {
+		return nil
+	}
+	uses := map[*compiledSource][]string{}
+	if err := filepath.WalkDir(output, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(file, ".class") {
+			return err
+		}
+		relative, err := filepath.Rel(output, file)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(relative), ".class")
+		if owned[name] {
+			return nil
+		}
+
+		member, err := babe.JarMemberFromFile(file)
+		if err != nil {
+			return err
+		}
+		class, err := member.GetAsClass()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", file, err)
+		}
+		// Classes javac found on the sourcepath by itself are not tracked, they are compiled again when needed
+		pool := newConstantPool(&class)
+		compiled, ok := state.Sources[compiledFrom(&class, pool, name)]
+		if !ok {
+			return nil
+		}
+		compiled.Classes = append(compiled.Classes, name)
+		uses[compiled] = append(uses[compiled], classReferences(&class)...)
+		for _, constant := range pool {
+			if utf8, ok := constant.(*babe.Utf8Info); ok {
+				for _, match := range signatureClasses.FindAllStringSubmatch(utf8.String(), -1) {
+					uses[compiled] = append(uses[compiled], match[1])
+				}
+			}
+		}
+		for field, value := range fieldConstants(&class, pool) {
+			if compiled.Constants == nil {
+				compiled.Constants = map[string]string{}
+			}
+			compiled.Constants[name+"."+field] = value
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Only classes of the module matter, as the classpath is tracked as a whole
+	classes := map[string]bool{}
+	for _, source := range state.Sources {
+		for _, class := range source.Classes {
+			classes[class] = true
+		}
+	}
+	for source, references := range uses {
+		for _, class := range references {
+			if classes[class] && !slices.Contains(source.Classes, class) && !slices.Contains(source.Uses, class) {
+				source.Uses = append(source.Uses, class)
+			}
+		}
+		slices.Sort(source.Classes)
+		slices.Sort(source.Uses)
+	}
+	return nil
+}
+
+// compiledFrom returns the source a class was compiled from, relative to its source directory. It is named by the
+// SourceFile attribute, or else by the outermost class.
+func compiledFrom(class *babe.Class, pool constantPool, name string) string {
+	pkg := path.Dir(name)
+	for _, attribute := range class.Attributes {
+		if pool.utf8(attribute.AttributeNameIndex) != "SourceFile" || len(attribute.Data) != 2 {
+			continue
+		}
+		if file := pool.utf8(binary.BigEndian.Uint16(attribute.Data)); file != "" {
+			return path.Join(pkg, file)
+		}
+	}
+	return path.Join(pkg, strings.SplitN(path.Base(name), "$", 2)[0]+".java")
+}
+
+// fieldConstants returns the values of the constant fields of a class, keyed by their name.
+func fieldConstants(class *babe.Class, pool constantPool) map[string]string {
+	constants := map[string]string{}
+	for _, field := range class.Fields {
+		for _, attribute := range field.Attributes {
+			if pool.utf8(attribute.AttributeNameIndex) != "ConstantValue" || len(attribute.Data) != 2 {
+				continue
+			}
+			value := pool.utf8(field.DescriptorIndex) + "="
+			switch constant := pool.get(binary.BigEndian.Uint16(attribute.Data)).(type) {
+			case *babe.IntegerInfo:
+				value += fmt.Sprint(constant.Bytes)
+			case *babe.FloatInfo:
+				value += fmt.Sprint(constant.Bytes)
+			case *babe.LongInfo:
+				value += fmt.Sprint(constant.GetLong())
+			case *babe.DoubleInfo:
+				value += fmt.Sprint(constant.GetLong())
+			case *babe.StringInfo:
+				value += strconv.Quote(pool.utf8(constant.StringIndex))
+			}
+			constants[pool.utf8(field.NameIndex)] = value
+		}
+	}
+	return constants
+}
+
+// constants returns the constants of every source.
+func (state compileState) constants() map[string]string {
+	constants := map[string]string{}
+	for _, source := range state.Sources {
+		maps.Copy(constants, source.Constants)
+	}
+	return constants
+}
+
+// generatedClasses sums up the class files in the output that no source of the state owns, which are the ones
+// written by annotation processors.
+func generatedClasses(output string, state compileState) (map[string]string, error) {
+	owned := map[string]bool{}
+	for _, source := range state.Sources {
+		for _, class := range source.Classes {
+			owned[class] = true
+		}
+	}
+	generated := map[string]string{}
+	if !fss.Exists(output) {
+		return generated, nil
+	}
+	err := filepath.WalkDir(output, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(file, ".class") {
+			return err
+		}
+		relative, err := filepath.Rel(output, file)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(relative), ".class")
+		if !owned[name] {
+			generated[name], err = Sha256Sum(file)
+		}
+		return err
+	})
+	return generated, err
+}
+
+// classpathDigest sums up the classpath by the size and modification time of its entries, and the newest file of
+// directories.
+func classpathDigest(classpath []string) string {
+	var digest []string
+	for _, entry := range classpath {
+		info, err := os.Stat(entry)
+		switch {
+		case err != nil:
+			digest = append(digest, entry)
+		case info.IsDir():
+			newest, _ := getNewestTime(entry)
+			digest = append(digest, fmt.Sprintf("%s@%d", entry, newest.UnixNano()))
+		default:
+			digest = append(digest, fmt.Sprintf("%s@%d:%d", entry, info.Size(), info.ModTime().UnixNano()))
+		}
+	}
+	digest = append(digest, Java.GetPath())
+	sum := sha256.Sum256([]byte(strings.Join(digest, string(os.PathListSeparator))))
+	return hex.EncodeToString(sum[:])
+}
+
+func readCompileState(file string) (state compileState, ok bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return state, false
+	}
+	if err := json.Unmarshal(data, &state); err != nil || state.Sources == nil {
+		return state, false
+	}
+	return state, true
+}
+
+func (state compileState) write(file string) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, os.ModePerm)
+}
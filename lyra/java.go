@@ -1,12 +1,16 @@
 package lyra
 
 import (
+	"errors"
+	"fmt"
 	"github.com/mrnavastar/assist/fs"
 	"github.com/urfave/cli/v2"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -24,6 +28,9 @@ func init() {
 
 func javaInfo(ctx *cli.Context) error {
 	println(Java.GetPath())
+	if version, err := Java.Version(); err == nil {
+		println(fmt.Sprintf("version %d", version))
+	}
 	return nil
 }
 
@@ -38,18 +45,76 @@ func (*JavaAPI) IsInstalled() bool {
 	return fs.Exists(path.Join(Java.GetPath(), "java"+getExtension())) && fs.Exists(path.Join(Java.GetPath(), "javac"+getExtension()))
 }
 
+var javaVersionPattern = regexp.MustCompile(`version "([^"]+)"`)
+
+// Version returns the feature release of the JDK, like 8 or 17. Projects are compiled for the JDK they are built
+// with, so this is also the version dependencies have to support.
+func (*JavaAPI) Version() (int, error) {
+	Java.mu.Lock()
+	if Java.version != 0 {
+		defer Java.mu.Unlock()
+		return Java.version, nil
+	}
+	Java.mu.Unlock()
+
+	version := ""
+	// JDKs describe themselves in a release file next to bin
+	if data, err := os.ReadFile(path.Join(Java.GetPath(), "..", "release")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if value, ok := strings.CutPrefix(line, "JAVA_VERSION="); ok {
+				version = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	if version == "" {
+		out, err := exec.Command(path.Join(Java.GetPath(), "java"+getExtension()), "-version").CombinedOutput()
+		if err != nil {
+			return 0, err
+		}
+		groups := javaVersionPattern.FindStringSubmatch(string(out))
+		if groups == nil {
+			return 0, errors.New("could not determine the version of the JDK at " + Java.GetPath())
+		}
+		version = groups[1]
+	}
+
+	// Versions before 9 are 1.x
+	version = strings.TrimPrefix(version, "1.")
+	feature, _, _ := strings.Cut(version, ".")
+	number, err := strconv.Atoi(strings.TrimFunc(feature, func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected JDK version %s", version)
+	}
+
+	Java.mu.Lock()
+	defer Java.mu.Unlock()
+	Java.version = number
+	return number, nil
+}
+
 type JavaCompileOptions struct {
-	Classpath []string
-	Sources   []string
+	// Output is the directory class files are written to, build/output if empty
+	Output        string
+	Classpath     []string
+	ProcessorPath []string
+	Sources       []string
 }
 
 func (*JavaAPI) Compile(options JavaCompileOptions) error {
+	output := options.Output
+	if output == "" {
+		output = "build/output"
+	}
 	cmd := exec.Command(path.Join(Java.GetPath(), "javac"+getExtension()),
-		"-d", "build/output",
+		"-d", output,
 		"-cp", strings.Join(options.Classpath, string(os.PathListSeparator)),
 		"-encoding", "utf8",
-		"-sourcepath", "build/override:src", strings.Join(options.Sources, " "),
+		"-sourcepath", "build/override:src",
 	)
+	if len(options.ProcessorPath) > 0 {
+		cmd.Args = append(cmd.Args, "-processorpath", strings.Join(options.ProcessorPath, string(os.PathListSeparator)))
+	}
+	cmd.Args = append(cmd.Args, options.Sources...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
@@ -65,6 +130,7 @@ type JavaRunOptions struct {
 
 func (*JavaAPI) Run(options JavaRunOptions) error {
 	cmd := exec.Command(path.Join(Java.GetPath(), "java"+getExtension()))
+	cmd.Args = append(cmd.Args, options.JvmArgs...)
 	if len(options.Classpath) > 0 {
 		cmd.Args = append(cmd.Args, "-cp", strings.Join(options.Classpath, string(os.PathListSeparator)))
 	}
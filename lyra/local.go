@@ -0,0 +1,300 @@
+package lyra
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mrnavastar/assist/fs"
+)
+
+// LocalGroup is the group of local jars and directories, which have no coordinate of their own.
+const LocalGroup = "local"
+
+// localBuildsEnv lists the projects a build was started for, so that projects depending on each other fail instead
+// of building each other forever.
+const localBuildsEnv = "LYRA_LOCAL_BUILDS"
+
+// versionSuffix splits the version off a jar name like foo-1.2.3.jar.
+var versionSuffix = regexp.MustCompile(`^(.+?)-(\d[^/]*)$`)
+
+// localBuilds remembers the result of building each sibling project, they are only built once per run.
+var localBuilds sync.Map
+
+type localBuild struct {
+	once sync.Once
+	err  error
+}
+
+func init() {
+	Dependency.RegisterParser(localParser)
+	Dependency.RegisterResolver("file", resolveFile)
+	Dependency.RegisterResolver("project", resolveProject)
+	Dependency.RegisterUpdater(updateProject)
+}
+
+// isLocalSlug reports whether a slug names a file instead of a coordinate. Coordinates never contain a path separator.
+func isLocalSlug(slug string) bool {
+	if strings.Contains(slug, "://") && !strings.HasPrefix(slug, "file:") {
+		return false
+	}
+	return strings.HasPrefix(slug, "file:") || strings.HasPrefix(slug, "~") || isCoordinatePath(slug) ||
+		strings.HasSuffix(slug, ".jar") || filepath.IsAbs(slug)
+}
+
+// IsLocal reports whether a uri points at a file on this machine, like a vendored jar or a sibling project. Local
+// files change with the project, so they are never cached or locked.
+func IsLocal(uri string) bool {
+	return strings.HasPrefix(uri, "file:") || strings.HasPrefix(uri, "project:")
+}
+
+// localURI formats a path for lyra.json. Relative paths stay relative to the project, so that it can be moved
+// along with its vendored jars and siblings.
+func localURI(scheme string, file string) string {
+	if !filepath.IsAbs(file) {
+		return scheme + ":" + filepath.ToSlash(file)
+	}
+	file = filepath.ToSlash(file)
+	if !strings.HasPrefix(file, "/") {
+		// Windows paths like C:/libs need a leading slash to be a url path
+		file = "/" + file
+	}
+	return (&url.URL{Scheme: scheme, Path: file}).String()
+}
+
+// localPath returns the path a local uri points to, relative paths are relative to the project.
+func localPath(uri *url.URL) string {
+	file := uri.Opaque
+	if file == "" {
+		file = uri.Path
+		if strings.HasPrefix(file, "/") && filepath.VolumeName(file[1:]) != "" {
+			file = file[1:]
+		}
+	}
+	return filepath.FromSlash(file)
+}
+
+// localParser handles paths to jars, directories of classes and other lyra projects. Sibling projects bring their
+// dependencies along and get built whenever the classpath is needed.
+func localParser(slug string) (Artifact, error) {
+	if !isLocalSlug(slug) {
+		return Artifact{}, errors.New("not a local path: " + slug)
+	}
+	file := slug
+	if strings.HasPrefix(slug, "file:") {
+		parsed, err := url.Parse(slug)
+		if err != nil {
+			return Artifact{}, err
+		}
+		file = localPath(parsed)
+	}
+	if strings.HasPrefix(file, "~") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Artifact{}, err
+		}
+		file = filepath.Join(home, strings.TrimPrefix(file, "~"))
+	}
+	// A module of a sibling project is picked with ../shared-lib#module
+	module := ""
+	if dir, fragment, ok := strings.Cut(file, "#"); ok && !fs.Exists(file) {
+		file, module = dir, fragment
+	}
+	file = filepath.Clean(file)
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("%s does not exist", file)
+	}
+	if info.IsDir() && fs.Exists(filepath.Join(file, "lyra.json")) {
+		return readLocalProject(file, module)
+	}
+
+	artifact := Artifact{Group: LocalGroup, Name: strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))}
+	if groups := versionSuffix.FindStringSubmatch(artifact.Name); groups != nil {
+		artifact.Name, artifact.Version = groups[1], groups[2]
+	}
+	switch {
+	case info.IsDir():
+		artifact.Packaging = "dir"
+	case filepath.Ext(file) != ".jar":
+		artifact.Packaging = strings.TrimPrefix(filepath.Ext(file), ".")
+	}
+	artifact.Main = localURI("file", file)
+	return artifact, nil
+}
+
+// readProject reads the lyra.json of another project.
+func readProject(dir string) (projectProxy, error) {
+	proxy := projectProxy{}
+	data, err := os.ReadFile(filepath.Join(dir, "lyra.json"))
+	if err != nil {
+		return proxy, err
+	}
+	data, err = migrateProject(data)
+	if err != nil {
+		return proxy, fmt.Errorf("%s: %s", dir, err)
+	}
+	if err := json.Unmarshal(data, &proxy); err != nil {
+		return proxy, fmt.Errorf("%s: %s", dir, err)
+	}
+	if proxy.Name == "" {
+		return proxy, fmt.Errorf("%s has no project name", dir)
+	}
+	return proxy, nil
+}
+
+// readLocalProject turns another lyra project into an artifact that depends on everything that project depends on.
+// The module defaults to main, the one lyra init creates.
+func readLocalProject(dir string, module string) (Artifact, error) {
+	proxy, err := readProject(dir)
+	if err != nil {
+		return Artifact{}, err
+	}
+	if module == "" {
+		module = "main"
+	}
+	if !fs.Exists(filepath.Join(dir, "src", module)) {
+		return Artifact{}, fmt.Errorf("%s has no module named %s", dir, module)
+	}
+	artifact := Artifact{
+		Group: proxy.Group,
+		Name:  proxy.Name,
+		Main:  localURI("project", dir),
+	}
+	if artifact.Group == "" {
+		artifact.Group = LocalGroup
+	}
+	if module != "main" {
+		artifact.Name += "-" + module
+		artifact.Main += "#" + module
+	}
+
+	// Relative files of the sibling project are relative to this project instead
+	for _, dependency := range proxy.Artifacts {
+		rebased, err := rebaseLocal(dependency, func(uri *url.URL) (string, error) {
+			rebased := localURI(uri.Scheme, filepath.Join(dir, localPath(uri)))
+			if uri.Fragment != "" {
+				rebased += "#" + uri.Fragment
+			}
+			return rebased, nil
+		})
+		if err != nil {
+			return Artifact{}, err
+		}
+		artifact.Dependencies = append(artifact.Dependencies, rebased)
+	}
+	return artifact, nil
+}
+
+// rebaseLocal replaces every relative local uri in a dependency graph that was read from another project.
+func rebaseLocal(artifact Artifact, rebase func(uri *url.URL) (string, error)) (Artifact, error) {
+	if parsed, err := url.Parse(artifact.Main); err == nil && IsLocal(artifact.Main) && parsed.Opaque != "" {
+		if artifact.Main, err = rebase(parsed); err != nil {
+			return artifact, err
+		}
+	}
+	dependencies := artifact.Dependencies
+	artifact.Dependencies = nil
+	for _, dependency := range dependencies {
+		rebased, err := rebaseLocal(dependency, rebase)
+		if err != nil {
+			return artifact, err
+		}
+		artifact.Dependencies = append(artifact.Dependencies, rebased)
+	}
+	return artifact, nil
+}
+
+func resolveFile(uri *url.URL) (string, error) {
+	file, err := filepath.Abs(localPath(uri))
+	if err != nil {
+		return "", err
+	}
+	if !fs.Exists(file) {
+		return "", fmt.Errorf("%s does not exist", file)
+	}
+	return localURI("file", file), nil
+}
+
+// resolveProject builds a sibling project and returns the jar of the requested module.
+func resolveProject(uri *url.URL) (string, error) {
+	dir, err := filepath.Abs(localPath(uri))
+	if err != nil {
+		return "", err
+	}
+	module := uri.Fragment
+	if module == "" {
+		module = "main"
+	}
+	if err := buildLocalProject(dir); err != nil {
+		return "", err
+	}
+
+	jar := filepath.Join(dir, "build", "jar", module+".jar")
+	if !fs.Exists(jar) {
+		return "", fmt.Errorf("building %s did not produce %s", dir, path.Join("build/jar", module+".jar"))
+	}
+	return localURI("file", jar), nil
+}
+
+// buildLocalProject runs lyra build in another project, once per run. The build is incremental, so a project that
+// did not change is cheap to build again.
+func buildLocalProject(dir string) error {
+	value, _ := localBuilds.LoadOrStore(dir, &localBuild{})
+	build := value.(*localBuild)
+	build.once.Do(func() {
+		building := filepath.SplitList(os.Getenv(localBuildsEnv))
+		if current, err := os.Getwd(); err == nil {
+			building = append(building, current)
+		}
+		if slices.Contains(building, dir) {
+			build.err = fmt.Errorf("%s depends on a project that depends on it", dir)
+			return
+		}
+
+		executable, err := os.Executable()
+		if err != nil {
+			build.err = err
+			return
+		}
+		cmd := exec.Command(executable)
+		if IsOffline() {
+			cmd.Args = append(cmd.Args, "--offline")
+		}
+		cmd.Args = append(cmd.Args, "build")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), localBuildsEnv+"="+strings.Join(building, string(os.PathListSeparator)))
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			build.err = fmt.Errorf("failed to build %s: %s", dir, err)
+		}
+	})
+	return build.err
+}
+
+// updateProject picks up changes to the dependencies of a sibling project.
+func updateProject(artifact Artifact) (Artifact, bool, error) {
+	parsed, err := url.Parse(artifact.Main)
+	if err != nil || parsed.Scheme != "project" {
+		return artifact, false, nil
+	}
+	updated, err := readLocalProject(localPath(parsed), parsed.Fragment)
+	if err != nil {
+		return artifact, false, err
+	}
+	updated.Main = artifact.Main
+	current, _ := json.Marshal(artifact.Dependencies)
+	next, _ := json.Marshal(updated.Dependencies)
+	return updated, string(current) != string(next), nil
+}
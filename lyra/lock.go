@@ -0,0 +1,176 @@
+package lyra
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mrnavastar/assist/fs"
+	"github.com/urfave/cli/v2"
+)
+
+// lockSchema is the current version of the lyra.lock format.
+const lockSchema = 1
+
+// LockedArtifact pins the exact file that was downloaded for an artifact url.
+type LockedArtifact struct {
+	Group   string `json:",omitempty"`
+	Name    string `json:",omitempty"`
+	Version string `json:",omitempty"`
+	URL     string
+	Sha256  string
+}
+
+// Lock is the in memory view of lyra.lock. Every file resolved for an artifact is checked against it, and files that
+// are not locked yet get recorded.
+type Lock struct {
+	mu sync.Mutex
+
+	artifacts map[string]LockedArtifact
+}
+
+type lockProxy struct {
+	Schema    int
+	Artifacts []LockedArtifact `json:",omitempty"`
+}
+
+func init() {
+	Command.Register(&cli.Command{
+		Name:   "lock",
+		Args:   false,
+		Action: lock,
+	})
+}
+
+func (lock *Lock) Load() error {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	lock.artifacts = map[string]LockedArtifact{}
+	if !fs.Exists("lyra.lock") {
+		return nil
+	}
+
+	data, err := os.ReadFile("lyra.lock")
+	if err != nil {
+		return err
+	}
+	proxy := lockProxy{}
+	if err := json.Unmarshal(data, &proxy); err != nil {
+		return err
+	}
+	if proxy.Schema > lockSchema {
+		return fmt.Errorf("lyra.lock uses schema %d but this version of lyra only supports up to %d", proxy.Schema, lockSchema)
+	}
+	for _, artifact := range proxy.Artifacts {
+		lock.artifacts[artifact.URL] = artifact
+	}
+	return nil
+}
+
+func (lock *Lock) Save() error {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if !fs.Exists("lyra.json") || (len(lock.artifacts) == 0 && !fs.Exists("lyra.lock")) {
+		return nil
+	}
+
+	proxy := lockProxy{Schema: lockSchema}
+	for _, artifact := range lock.artifacts {
+		proxy.Artifacts = append(proxy.Artifacts, artifact)
+	}
+	// Keep the file stable so it diffs nicely
+	slices.SortFunc(proxy.Artifacts, func(a, b LockedArtifact) int {
+		return strings.Compare(a.URL, b.URL)
+	})
+
+	data, err := json.MarshalIndent(proxy, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile("lyra.lock", data, os.ModePerm)
+}
+
+// Clear forgets every locked artifact.
+func (lock *Lock) Clear() {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	lock.artifacts = map[string]LockedArtifact{}
+}
+
+// Get returns the locked entry for an artifact url.
+func (lock *Lock) Get(uri string) (LockedArtifact, bool) {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	locked, ok := lock.artifacts[uri]
+	return locked, ok
+}
+
+// Remove forgets the locked entry for an artifact url.
+func (lock *Lock) Remove(uri string) {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	delete(lock.artifacts, uri)
+}
+
+// Verify checks a resolved file against the digest locked for its url, recording it if it isn't locked yet.
+func (lock *Lock) Verify(artifact Artifact, uri string, file string) error {
+	if !fs.Exists("lyra.json") {
+		return nil
+	}
+
+	digest, err := Sha256Sum(file)
+	if err != nil {
+		return err
+	}
+
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.artifacts == nil {
+		lock.artifacts = map[string]LockedArtifact{}
+	}
+	locked, ok := lock.artifacts[uri]
+	if !ok {
+		lock.artifacts[uri] = LockedArtifact{
+			Group:   artifact.Group,
+			Name:    artifact.Name,
+			Version: artifact.Version,
+			URL:     uri,
+			Sha256:  digest,
+		}
+		return nil
+	}
+	// Snapshots without a timestamp in their file name are replaced in place, so they can't be pinned
+	if locked.Sha256 != digest && IsSnapshot(locked.Version) && strings.Contains(uri, "-SNAPSHOT.") {
+		locked.Sha256 = digest
+		lock.artifacts[uri] = locked
+		return nil
+	}
+	if locked.Sha256 != digest {
+		return fmt.Errorf("checksum mismatch for %s (%s): lyra.lock expects sha256 %s but got %s", uri, file, locked.Sha256, digest)
+	}
+	return nil
+}
+
+func lock(ctx *cli.Context) error {
+	if !fs.Exists("lyra.json") {
+		return nil
+	}
+
+	project := GetCurrentProject()
+	project.lock.Clear()
+	for _, artifact := range Flatten(project.Dependencies()) {
+		if _, err := artifact.Resolve(); err != nil {
+			return err
+		}
+		if _, err := artifact.ResolveSources(); err != nil {
+			return err
+		}
+		if _, err := artifact.ResolveDocs(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package lyra
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mrnavastar/assist/fs"
+	"github.com/urfave/cli/v2"
+)
+
+// outdatedArtifact describes the upgrades available for a direct dependency.
+type outdatedArtifact struct {
+	artifact Artifact
+	versions []string
+	// latestMajor is the newest release with the same major version
+	latestMajor string
+	latest      string
+}
+
+// findOutdated looks up the available versions of every artifact, artifacts no version lister knows are skipped with
+// a warning.
+func findOutdated(artifacts []Artifact) (outdated []outdatedArtifact) {
+	for _, artifact := range artifacts {
+		if artifact.Version == "" || IsLocal(artifact.Main) || isGit(artifact.Main) {
+			continue
+		}
+		versions, err := Dependency.ListVersions(artifact)
+		if err != nil || len(versions) == 0 {
+			println(fmt.Sprintf("warning: could not list versions of %s:%s: %v", artifact.Group, artifact.Name, err))
+			continue
+		}
+		outdated = append(outdated, outdatedArtifact{
+			artifact:    artifact,
+			versions:    versions,
+			latestMajor: LatestVersion(versions, artifact.Version, 1),
+			latest:      LatestVersion(versions, artifact.Version, 0),
+		})
+	}
+	return outdated
+}
+
+func dependencyOutdated(ctx *cli.Context) error {
+	if !fs.Exists("lyra.json") {
+		return errors.New("no project in current directory")
+	}
+
+	outdated := findOutdated(GetCurrentProject().Dependencies())
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "DEPENDENCY\tCURRENT\tLATEST IN MAJOR\tLATEST")
+	for _, entry := range outdated {
+		if CompareVersions(entry.latest, entry.artifact.Version) <= 0 {
+			continue
+		}
+		fmt.Fprintf(writer, "%s:%s\t%s\t%s\t%s\n", entry.artifact.Group, entry.artifact.Name, entry.artifact.Version, entry.latestMajor, entry.latest)
+	}
+	return writer.Flush()
+}
+
+func dependencyUpgrade(ctx *cli.Context) error {
+	if !fs.Exists("lyra.json") {
+		return errors.New("no project in current directory")
+	}
+
+	// --patch keeps major.minor, --minor keeps the major version
+	count := 0
+	if ctx.Bool("minor") {
+		count = 1
+	}
+	if ctx.Bool("patch") {
+		count = 2
+	}
+
+	project := GetCurrentProject()
+	artifacts := project.Dependencies()
+	if ctx.Args().Present() {
+		artifacts = nil
+		for _, slug := range ctx.Args().Slice() {
+			wanted := Dependency.ParseMavenCoordinate(slug)
+			found := false
+			for _, artifact := range project.Dependencies() {
+				if artifact.SameAs(wanted) {
+					artifacts = append(artifacts, artifact)
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("%s is not a direct dependency of this project", slug)
+			}
+		}
+	}
+
+	var replaced []Artifact
+	for _, entry := range findOutdated(artifacts) {
+		version := LatestVersion(entry.versions, entry.artifact.Version, count)
+		if version == "" || CompareVersions(version, entry.artifact.Version) <= 0 {
+			continue
+		}
+
+		// Getting the new version replaces the old entry and resolves its transitive dependencies again
+		fmt.Printf("%s:%s %s -> %s\n", entry.artifact.Group, entry.artifact.Name, entry.artifact.Version, version)
+		upgraded := entry.artifact
+		upgraded.Version = version
+		if err := project.Get(upgraded.Coordinate(), entry.artifact.EffectiveScope()); err != nil {
+			return err
+		}
+		replaced = append(replaced, entry.artifact)
+	}
+	// The old versions stay in the cache, other projects may still use them
+	return project.releaseUnreachable(replaced, false)
+}
@@ -0,0 +1,130 @@
+package lyra
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/mrnavastar/assist/fs"
+)
+
+// jarPackagings are packagings whose file is a plain jar that goes on the classpath as is.
+var jarPackagings = []string{"", "jar", "bundle", "test-jar", "maven-plugin", "ejb"}
+
+// Extension returns the file extension used for a packaging, a pom packaging has no file of its own.
+func Extension(packaging string) string {
+	if slices.Contains(jarPackagings, packaging) {
+		return "jar"
+	}
+	return packaging
+}
+
+// Key identifies an artifact during mediation, artifacts with the same key are versions of each other. The classifier
+// is part of it, so that e.g. lwjgl and its natives are both kept.
+func (artifact Artifact) Key() string {
+	key := artifact.Group + ":" + artifact.Name
+	if artifact.Classifier != "" {
+		key += ":" + artifact.Classifier
+	}
+	return key
+}
+
+// Coordinate formats the artifact the way ParseMavenCoordinate reads it, group:name:version[@packaging]. Classifiers
+// use the maven form group:name:packaging:classifier:version, so that versions not starting with a digit read back
+// as versions.
+func (artifact Artifact) Coordinate() string {
+	if artifact.Classifier != "" && artifact.Version != "" {
+		packaging := artifact.Packaging
+		if packaging == "" {
+			packaging = "jar"
+		}
+		return strings.Join([]string{artifact.Group, artifact.Name, packaging, artifact.Classifier, artifact.Version}, ":")
+	}
+
+	coordinate := artifact.Group + ":" + artifact.Name
+	if artifact.Version != "" {
+		coordinate += ":" + artifact.Version
+	}
+	if artifact.Classifier != "" {
+		coordinate += ":" + artifact.Classifier
+	}
+	if Extension(artifact.Packaging) != "jar" {
+		coordinate += "@" + artifact.Packaging
+	}
+	return coordinate
+}
+
+func startsWithDigit(value string) bool {
+	return value != "" && unicode.IsDigit(rune(value[0]))
+}
+
+// classpathEntry turns the resolved file of an artifact into what goes on the classpath. Jars and local directories of
+// classes are used as is, the classes of an aar are extracted and any other packaging, like zip, is never put on the
+// classpath.
+func (artifact Artifact) classpathEntry(resolved string) (string, error) {
+	switch Extension(artifact.Packaging) {
+	case "jar", "dir":
+		return resolved, nil
+	case "aar":
+		return extractAarClasses(resolved)
+	}
+	return "", nil
+}
+
+// extractAarClasses copies the classes.jar out of an android archive into the cache, once per archive digest.
+func extractAarClasses(aar string) (string, error) {
+	digest, err := Sha256Sum(aar)
+	if err != nil {
+		return "", err
+	}
+	cache, err := GetCache()
+	if err != nil {
+		return "", err
+	}
+	classes := path.Join(cache, "aar", digest, "classes.jar")
+	if fs.Exists(classes) {
+		return classes, nil
+	}
+
+	reader, err := zip.OpenReader(aar)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		if file.Name != "classes.jar" {
+			continue
+		}
+		if err := os.MkdirAll(path.Dir(classes), os.ModePerm); err != nil {
+			return "", err
+		}
+		in, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		defer in.Close()
+
+		temp, err := os.CreateTemp(path.Dir(classes), "classes-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(temp.Name())
+		_, err = io.Copy(temp, in)
+		temp.Close()
+		if err != nil {
+			return "", err
+		}
+		return classes, os.Rename(temp.Name(), classes)
+	}
+	return "", fmt.Errorf("%s has no classes.jar", aar)
+}
+
+// isCoordinatePath reports whether part of a coordinate looks like a path or url instead.
+func isCoordinatePath(part string) bool {
+	return strings.ContainsAny(part, "/\\")
+}
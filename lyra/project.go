@@ -7,8 +7,8 @@ import (
 	"fmt"
 	"github.com/urfave/cli/v2"
 	"net/http"
-	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 
@@ -22,15 +22,44 @@ type Project struct {
 
 	name      string
 	groupId   string
-	repos     []url.URL
+	repos     []Repository
 	artifacts []Artifact
+	platforms []Artifact
 	plugins   []string
+	lock      Lock
+
+	substitutions []Substitution
+
+	snapshots struct {
+		once sync.Once
+		err  error
+	}
 }
 
 type projectProxy struct {
-	Name      string     `json:",omitempty"`
-	Group     string     `json:",omitempty"`
-	Artifacts []Artifact `json:",omitempty"`
+	Schema    int
+	Name      string       `json:",omitempty"`
+	Group     string       `json:",omitempty"`
+	Plugins   []string     `json:",omitempty"`
+	Repos     []Repository `json:",omitempty"`
+	Platforms []Artifact   `json:",omitempty"`
+	Artifacts []Artifact   `json:",omitempty"`
+	// Substitutions are applied to the whole graph, see Flatten
+	Substitutions []Substitution `json:",omitempty"`
+}
+
+// projectSchema is the current version of the lyra.json format.
+const projectSchema = 1
+
+// projectMigrations upgrade a raw lyra.json document, the migration at index i takes schema i to schema i+1.
+var projectMigrations = []func(data map[string]any) error{
+	// Schema 0 never persisted repositories, so restore the default that initProject used to add
+	func(data map[string]any) error {
+		if _, ok := data["Repos"]; !ok {
+			data["Repos"] = []any{map[string]any{"URL": MavenCentral}}
+		}
+		return nil
+	},
 }
 
 func (project *Project) modify(modifier func(*Project)) {
@@ -57,21 +86,36 @@ func (project *Project) Dependencies() []Artifact {
 	return project.artifacts
 }
 
-func (project *Project) Repos() []url.URL {
+func (project *Project) Repos() []Repository {
 	project.mu.Lock()
 	defer project.mu.Unlock()
 	return project.repos
 }
 
-func (project *Project) GetClasspath() (classpath []string, err error) {
-	for _, artifact := range project.Dependencies() {
-		resolved, err := artifact.Resolve()
-		if err != nil {
-			return nil, err
-		}
-		classpath = append(classpath, resolved)
-	}
-	return classpath, nil
+// Platforms returns the boms whose managed versions are used for dependencies added without a version.
+func (project *Project) Platforms() []Artifact {
+	project.mu.Lock()
+	defer project.mu.Unlock()
+	return project.platforms
+}
+
+// Substitutions returns the artifacts that are replaced everywhere in the dependency graph, see Substitute.
+func (project *Project) Substitutions() []Substitution {
+	project.mu.Lock()
+	defer project.mu.Unlock()
+	return project.substitutions
+}
+
+func (project *Project) Plugins() []string {
+	project.mu.Lock()
+	defer project.mu.Unlock()
+	return project.plugins
+}
+
+// GetClasspath resolves every direct and transitive dependency of the project that is needed at runtime, see Flatten
+// for how conflicting versions are picked.
+func (project *Project) GetClasspath() ([]string, error) {
+	return project.GetRuntimeClasspath()
 }
 
 func (project *Project) GoWith(id string, f func() error) {
@@ -104,20 +148,44 @@ func (project *Project) Wait() error {
 	return nil
 }
 
-func (project *Project) AddRepo(repo url.URL) error {
+func (project *Project) AddRepo(repo Repository) error {
 	if !fs.Exists("lyra.json") {
 		return nil
 	}
 
-	for _, r := range project.repos {
-		if r == repo {
+	for _, r := range project.Repos() {
+		if r.SameAs(repo) {
 			return nil
 		}
 	}
 
-	_, err := http.Get(repo.String())
+	location, err := repo.Location()
 	if err != nil {
-		return fmt.Errorf("repo is unreachable: %s", err)
+		return err
+	}
+	if location.User != nil {
+		return errors.New("credentials must not be part of the repo url, put them in the user credentials file instead")
+	}
+	if !IsOffline() {
+		request, err := http.NewRequest("GET", repo.URL, nil)
+		if err != nil {
+			return err
+		}
+		if err := repo.Authenticate(request); err != nil {
+			return err
+		}
+		client, err := HttpClient()
+		if err != nil {
+			return err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return fmt.Errorf("repo is unreachable: %s", err)
+		}
+		response.Body.Close()
+		if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+			return fmt.Errorf("repo rejected the request: %s, check the credentials for %s", response.Status, repo.Name())
+		}
 	}
 
 	project.modify(func(project *Project) {
@@ -126,6 +194,56 @@ func (project *Project) AddRepo(repo url.URL) error {
 	return nil
 }
 
+// RemoveRepo drops the repository with the given url or id. It returns false if there was no such repository.
+func (project *Project) RemoveRepo(urlOrId string) (removed bool) {
+	project.modify(func(project *Project) {
+		for i, repo := range project.repos {
+			if repo.Matches(urlOrId) {
+				project.repos = append(project.repos[:i:i], project.repos[i+1:]...)
+				removed = true
+				return
+			}
+		}
+	})
+	return removed
+}
+
+// MoveRepo moves a repository in front of another one, repositories are always asked in order.
+func (project *Project) MoveRepo(urlOrId string, before string) (err error) {
+	project.modify(func(project *Project) {
+		from := slices.IndexFunc(project.repos, func(repo Repository) bool { return repo.Matches(urlOrId) })
+		if from == -1 {
+			err = errors.New("no such repo: " + urlOrId)
+			return
+		}
+		repo := project.repos[from]
+		repos := slices.Delete(slices.Clone(project.repos), from, from+1)
+
+		to := slices.IndexFunc(repos, func(repo Repository) bool { return repo.Matches(before) })
+		if to == -1 {
+			err = errors.New("no such repo: " + before)
+			return
+		}
+		project.repos = slices.Insert(repos, to, repo)
+	})
+	return err
+}
+
+// AddPlugin records a plugin as required by the current project.
+func (project *Project) AddPlugin(slug string) {
+	if !fs.Exists("lyra.json") {
+		return
+	}
+	project.modify(func(project *Project) {
+		for _, plugin := range project.plugins {
+			if plugin == slug {
+				return
+			}
+		}
+		project.plugins = append(project.plugins, slug)
+	})
+}
+
 func (project *Project) AddDependency(artifact Artifact) error {
 	if !fs.Exists("lyra.json") {
 		return nil
@@ -138,11 +256,12 @@ func (project *Project) AddDependency(artifact Artifact) error {
 			break
 		}
 	}
-	_, err := artifact.Resolve()
-	if err != nil {
-		return err
+	for _, resolved := range Flatten([]Artifact{artifact}) {
+		if _, err := resolved.Resolve(); err != nil {
+			return err
+		}
 	}
-	_, err = artifact.ResolveSources()
+	_, err := artifact.ResolveSources()
 	if err != nil {
 		return err
 	}
@@ -160,43 +279,191 @@ func (project *Project) AddDependency(artifact Artifact) error {
 	return nil
 }
 
+// AddPlatform imports the managed versions of a bom, replacing another version of the same bom. Platforms declared
+// first win when more than one manages the same artifact.
+func (project *Project) AddPlatform(platform Artifact) error {
+	if !fs.Exists("lyra.json") {
+		return nil
+	}
+	if platform.Group == "" || platform.Name == "" || platform.Version == "" {
+		return errors.New("platforms need a group:name:version")
+	}
+	managed, err := Dependency.ManagedVersions(platform)
+	if err != nil {
+		return fmt.Errorf("failed to import platform %s: %w", platform.Coordinate(), err)
+	}
+	if len(managed) == 0 {
+		return fmt.Errorf("%s does not manage any versions", platform.Coordinate())
+	}
+
+	project.modify(func(project *Project) {
+		for i, existing := range project.platforms {
+			if existing.SameAs(platform) {
+				project.platforms[i] = platform
+				return
+			}
+		}
+		project.platforms = append(project.platforms, platform)
+	})
+	return nil
+}
+
+// RemovePlatform drops a platform, dependencies keep the versions it supplied until they are added again.
+func (project *Project) RemovePlatform(platform Artifact) (removed bool) {
+	project.modify(func(project *Project) {
+		for i, existing := range project.platforms {
+			if existing.SameAs(platform) {
+				project.platforms = append(project.platforms[:i:i], project.platforms[i+1:]...)
+				removed = true
+				return
+			}
+		}
+	})
+	return removed
+}
+
+// UpdateSnapshots re-resolves the direct snapshot dependencies whose repositories are due for an update, and sibling
+// projects whose dependencies changed, see RegisterUpdater. It only does any work the first time it is called.
+func (project *Project) UpdateSnapshots() error {
+	project.snapshots.once.Do(func() {
+		if !fs.Exists("lyra.json") {
+			return
+		}
+		for _, artifact := range project.Dependencies() {
+			if !IsSnapshot(artifact.Version) && !strings.HasPrefix(artifact.Main, "project:") {
+				continue
+			}
+			for _, updater := range Dependency.updaters {
+				updated, changed, err := updater(artifact)
+				if err != nil {
+					project.snapshots.err = err
+					return
+				}
+				if !changed {
+					continue
+				}
+				updated.Scope = artifact.Scope
+				updated.Exclude = artifact.Exclude
+				if err := project.AddDependency(updated); err != nil {
+					project.snapshots.err = err
+					return
+				}
+				// The previous build will never be resolved again
+				if artifact.Main != updated.Main {
+					project.lock.Remove(artifact.Main)
+				}
+				println("updated " + artifact.Coordinate())
+				break
+			}
+		}
+	})
+	return project.snapshots.err
+}
+
+// RemoveDependency drops a direct dependency, along with every transitive artifact only it pulled in. It returns
+// false if the project did not depend on the artifact.
+func (project *Project) RemoveDependency(artifact Artifact) (removed bool) {
+	project.modify(func(project *Project) {
+		for i, existingArtifact := range project.artifacts {
+			if existingArtifact.SameAs(artifact) {
+				project.artifacts = append(project.artifacts[:i:i], project.artifacts[i+1:]...)
+				removed = true
+				return
+			}
+		}
+	})
+	return removed
+}
+
 func (project *Project) Load() error {
 	project.groups = make(map[string]*errgroup.Group)
 	if !fs.Exists("lyra.json") {
 		return nil
 	}
+	if err := project.lock.Load(); err != nil {
+		return err
+	}
 	data, err := os.ReadFile("lyra.json")
 	if err != nil {
 		return err
 	}
+	data, err = migrateProject(data)
+	if err != nil {
+		return err
+	}
 	proxy := projectProxy{}
 	if err := json.Unmarshal(data, &proxy); err != nil {
 		return err
 	}
 	project.name = proxy.Name
 	project.groupId = proxy.Group
+	project.plugins = proxy.Plugins
+	project.repos = proxy.Repos
 	project.artifacts = proxy.Artifacts
+	project.platforms = proxy.Platforms
+	project.substitutions = proxy.Substitutions
 	return nil
 }
 
+func migrateProject(data []byte) ([]byte, error) {
+	raw := map[string]any{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	schema := 0
+	if version, ok := raw["Schema"].(float64); ok {
+		schema = int(version)
+	}
+	if schema > projectSchema {
+		return nil, fmt.Errorf("lyra.json uses schema %d but this version of lyra only supports up to %d", schema, projectSchema)
+	}
+	if schema == projectSchema {
+		return data, nil
+	}
+
+	for _, migration := range projectMigrations[schema:] {
+		if err := migration(raw); err != nil {
+			return nil, fmt.Errorf("failed to migrate lyra.json: %s", err)
+		}
+	}
+	raw["Schema"] = projectSchema
+	return json.Marshal(raw)
+}
+
 func (project *Project) Save() error {
 	if err := project.Wait(); err != nil {
 		return err
 	}
 
+	// Don't create a lyra.json outside of a project
+	if project.name == "" && !fs.Exists("lyra.json") {
+		return nil
+	}
+
 	data, err := json.MarshalIndent(projectProxy{
+		Schema:    projectSchema,
 		Name:      project.name,
 		Group:     project.groupId,
+		Plugins:   project.plugins,
+		Repos:     project.repos,
+		Platforms: project.platforms,
 		Artifacts: project.artifacts,
+
+		Substitutions: project.substitutions,
 	}, "", "    ")
 	if err != nil {
 		return err
 	}
-
-	if len(data) <= 2 {
-		return nil
+	if err := os.WriteFile("lyra.json", data, os.ModePerm); err != nil {
+		return err
+	}
+	if dir, err := os.Getwd(); err == nil {
+		if err := Cache.RegisterProject(dir); err != nil {
+			return err
+		}
 	}
-	return os.WriteFile("lyra.json", data, os.ModePerm)
+	return project.lock.Save()
 }
 
 func init() {
@@ -210,6 +477,13 @@ func init() {
 			Name:   "classpath",
 			Args:   false,
 			Action: showClasspath,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "scope",
+					Usage: "one of compile, runtime, test or processor",
+					Value: ScopeRuntime,
+				},
+			},
 		},
 	})
 }
@@ -225,13 +499,10 @@ func initProject(ctx *cli.Context) error {
 	project.name = ctx.Args().First()
 	project.groupId = ctx.String("group")
 
-	parsed, err := url.Parse("https://repo.maven.apache.org/maven2")
-	if err != nil {
-		return err
-	}
-	if err := project.AddRepo(*parsed); err != nil {
-		return err
-	}
+	// lyra.json doesn't exist yet, so AddRepo would ignore the default repo
+	project.modify(func(project *Project) {
+		project.repos = append(project.repos, Repository{Id: "central", URL: MavenCentral})
+	})
 
 	if err := os.MkdirAll("src/main/resources", os.ModePerm); err != nil {
 		return err
@@ -243,7 +514,21 @@ func showClasspath(ctx *cli.Context) error {
 	if !fs.Exists("lyra.json") {
 		return nil
 	}
-	classpath, err := GetCurrentProject().GetClasspath()
+	project := GetCurrentProject()
+	var classpath []string
+	var err error
+	switch ctx.String("scope") {
+	case ScopeCompile:
+		classpath, err = project.GetCompileClasspath()
+	case ScopeRuntime:
+		classpath, err = project.GetRuntimeClasspath()
+	case ScopeTest:
+		classpath, err = project.GetTestClasspath()
+	case ScopeProcessor:
+		classpath, err = project.GetProcessorPath()
+	default:
+		return errors.New("unknown scope: " + ctx.String("scope"))
+	}
 	if err != nil {
 		return err
 	}
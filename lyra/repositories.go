@@ -0,0 +1,218 @@
+package lyra
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MavenCentral is the repository every new project starts with.
+const MavenCentral = "https://repo.maven.apache.org/maven2"
+
+// Update policies decide how often metadata and snapshots are checked for changes, interval:<minutes> is accepted too.
+const (
+	UpdateAlways = "always"
+	UpdateDaily  = "daily"
+	UpdateNever  = "never"
+)
+
+// RepositoryPolicy controls whether a repository is used for a kind of artifact (releases or snapshots) and how
+// often lyra should check it for updates.
+type RepositoryPolicy struct {
+	Disabled bool   `json:",omitempty"`
+	Update   string `json:",omitempty"`
+}
+
+type Repository struct {
+	Id  string `json:",omitempty"`
+	URL string `json:",omitempty"`
+	// Credentials names the section of the user credentials file used for this repository, the secrets themselves
+	// are never stored in lyra.json
+	Credentials string            `json:",omitempty"`
+	Releases    *RepositoryPolicy `json:",omitempty"`
+	Snapshots   *RepositoryPolicy `json:",omitempty"`
+	// Include and Exclude filter the groups fetched from this repository with glob patterns like net.fabricmc*, an
+	// empty Include accepts every group
+	Include []string `json:",omitempty"`
+	Exclude []string `json:",omitempty"`
+}
+
+// RepositoryFailure is why a single repository could not provide something.
+type RepositoryFailure struct {
+	Repo Repository
+	Err  error
+}
+
+// RepositoryErrors lists every repository that was tried, in order, and why each of them failed.
+type RepositoryErrors struct {
+	What     string
+	Failures []RepositoryFailure
+}
+
+// Location parses the repository url.
+func (repo Repository) Location() (*url.URL, error) {
+	return url.Parse(repo.URL)
+}
+
+// Name returns the id of the repository, or its url if no id was set.
+func (repo Repository) Name() string {
+	if repo.Id != "" {
+		return repo.Id
+	}
+	return repo.URL
+}
+
+func (repo Repository) SameAs(other Repository) bool {
+	return strings.TrimSuffix(repo.URL, "/") == strings.TrimSuffix(other.URL, "/")
+}
+
+// Matches reports whether the repository has the given url or id.
+func (repo Repository) Matches(urlOrId string) bool {
+	return repo.SameAs(Repository{URL: urlOrId}) || (repo.Id != "" && repo.Id == urlOrId)
+}
+
+func (repo Repository) AllowsReleases() bool {
+	return repo.Releases == nil || !repo.Releases.Disabled
+}
+
+func (repo Repository) AllowsSnapshots() bool {
+	return repo.Snapshots == nil || !repo.Snapshots.Disabled
+}
+
+// CredentialsId returns the id the credentials of this repository are stored under, which defaults to its id.
+func (repo Repository) CredentialsId() string {
+	if repo.Credentials != "" {
+		return repo.Credentials
+	}
+	return repo.Id
+}
+
+// Serves returns an error explaining why the repository must not be asked for an artifact, or nil if it may be. An
+// empty version only checks the group.
+func (repo Repository) Serves(artifact Artifact) error {
+	if len(repo.Include) > 0 && !matchesGroup(repo.Include, artifact.Group) {
+		return fmt.Errorf("group %s is not included", artifact.Group)
+	}
+	if matchesGroup(repo.Exclude, artifact.Group) {
+		return fmt.Errorf("group %s is excluded", artifact.Group)
+	}
+	if artifact.Version == "" {
+		return nil
+	}
+	if IsSnapshot(artifact.Version) {
+		if !repo.AllowsSnapshots() {
+			return fmt.Errorf("snapshots are disabled")
+		}
+	} else if !repo.AllowsReleases() {
+		return fmt.Errorf("releases are disabled")
+	}
+	return nil
+}
+
+func matchesGroup(patterns []string, group string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, group); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseUpdatePolicy validates an update policy, an empty policy is daily.
+func ParseUpdatePolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return UpdateDaily, nil
+	case UpdateAlways, UpdateDaily, UpdateNever:
+		return policy, nil
+	}
+	if minutes, ok := strings.CutPrefix(policy, "interval:"); ok {
+		if value, err := strconv.Atoi(minutes); err == nil && value > 0 {
+			return policy, nil
+		}
+	}
+	return "", errors.New("unknown update policy: " + policy)
+}
+
+// Due reports whether something fetched at the given time should be checked again.
+func (policy *RepositoryPolicy) Due(fetched time.Time) bool {
+	update := UpdateDaily
+	if policy != nil && policy.Update != "" {
+		update = policy.Update
+	}
+
+	switch update {
+	case UpdateAlways:
+		return true
+	case UpdateNever:
+		return false
+	}
+	interval := 24 * time.Hour
+	if minutes, ok := strings.CutPrefix(update, "interval:"); ok {
+		if value, err := strconv.Atoi(minutes); err == nil {
+			interval = time.Duration(value) * time.Minute
+		}
+	}
+	return time.Since(fetched) >= interval
+}
+
+// updated remembers the urls that were already fetched again during this run.
+var updated sync.Map
+
+// NeedsUpdate reports whether a cached file from this repository, like maven-metadata.xml, should be fetched again.
+// Files that are not cached always need fetching, while --refresh and --offline override the update policy. A file
+// is fetched again at most once per run.
+func (repo Repository) NeedsUpdate(uri string, snapshot bool) bool {
+	if IsOffline() {
+		return false
+	}
+	if _, ok := updated.Load(uri); ok {
+		return false
+	}
+
+	due := IsRefreshing()
+	if entry, ok := Cache.Entry(uri); !ok {
+		due = true
+	} else if snapshot {
+		due = due || repo.Snapshots.Due(entry.Fetched)
+	} else {
+		due = due || repo.Releases.Due(entry.Fetched)
+	}
+	if due {
+		updated.Store(uri, true)
+	}
+	return due
+}
+
+// Add records that a repository failed.
+func (errs *RepositoryErrors) Add(repo Repository, err error) {
+	errs.Failures = append(errs.Failures, RepositoryFailure{Repo: repo, Err: err})
+}
+
+func (errs *RepositoryErrors) Error() string {
+	if len(errs.Failures) == 0 {
+		return fmt.Sprintf("could not find %s, no repositories are configured", errs.What)
+	}
+	message := fmt.Sprintf("could not find %s in any repository:", errs.What)
+	for _, failure := range errs.Failures {
+		name := failure.Repo.Name()
+		if failure.Repo.Id != "" {
+			name += " (" + failure.Repo.URL + ")"
+		}
+		message += fmt.Sprintf("\n  %s: %s", name, failure.Err)
+	}
+	return message
+}
+
+func (errs *RepositoryErrors) Unwrap() []error {
+	var unwrapped []error
+	for _, failure := range errs.Failures {
+		unwrapped = append(unwrapped, failure.Err)
+	}
+	return unwrapped
+}
@@ -0,0 +1,122 @@
+package lyra
+
+import (
+	"errors"
+	"slices"
+)
+
+// Scopes decide which classpaths an artifact ends up on, they follow Maven semantics with the addition of a scope
+// for annotation processors.
+const (
+	ScopeCompile   = "compile"
+	ScopeRuntime   = "runtime"
+	ScopeProvided  = "provided"
+	ScopeTest      = "test"
+	ScopeProcessor = "processor"
+)
+
+// classpathScopes are the scopes that put an artifact on a classpath. The processor path is a graph of its own, see
+// mediationKey.
+var classpathScopes = []string{ScopeCompile, ScopeProvided, ScopeRuntime, ScopeTest}
+
+// ParseScope validates a scope name, an empty name is the compile scope.
+func ParseScope(scope string) (string, error) {
+	if scope == "" {
+		return ScopeCompile, nil
+	}
+	if scope != ScopeProcessor && !slices.Contains(classpathScopes, scope) {
+		return "", errors.New("unknown scope: " + scope)
+	}
+	return scope, nil
+}
+
+// EffectiveScope returns the scope of an artifact, defaulting to compile.
+func (artifact Artifact) EffectiveScope() string {
+	if artifact.Scope == "" {
+		return ScopeCompile
+	}
+	return artifact.Scope
+}
+
+// propagateScope returns the scope a transitive dependency ends up with when it is pulled in through a parent.
+func propagateScope(parent string, declared string) string {
+	switch parent {
+	case ScopeCompile:
+		if declared == ScopeRuntime {
+			return ScopeRuntime
+		}
+		return ScopeCompile
+	case ScopeRuntime:
+		return ScopeRuntime
+	}
+	// Provided, test and processor dependencies drag everything they need into their own scope
+	return parent
+}
+
+// mediationKey keys an artifact within the graph it is mediated in. Processors and everything they pull in are
+// mediated apart from the classpaths, so that neither changes the versions or scopes the other one ends up with.
+func mediationKey(artifact Artifact, scope string) string {
+	if scope == ScopeProcessor {
+		return ScopeProcessor + ":" + artifact.Key()
+	}
+	return artifact.Key()
+}
+
+// widerScope merges two scopes an artifact is reached through, so that it ends up on every classpath either one puts
+// it on. Provided and runtime each leave out one of the classpaths, together they need both like compile.
+func widerScope(a string, b string) string {
+	switch {
+	case a == b || b == ScopeTest:
+		return a
+	case a == ScopeTest:
+		return b
+	}
+	return ScopeCompile
+}
+
+// Classpath resolves every dependency of the project whose effective scope is one of the given scopes.
+func (project *Project) Classpath(scopes ...string) (classpath []string, err error) {
+	if err := project.UpdateSnapshots(); err != nil {
+		return nil, err
+	}
+	for _, artifact := range Flatten(project.Dependencies()) {
+		if !slices.Contains(scopes, artifact.Scope) {
+			continue
+		}
+		resolved, err := artifact.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		if resolved == "" {
+			continue
+		}
+		entry, err := artifact.classpathEntry(resolved)
+		if err != nil {
+			return nil, err
+		}
+		if entry != "" {
+			classpath = append(classpath, entry)
+		}
+	}
+	return classpath, nil
+}
+
+// GetCompileClasspath returns the classpath used to compile main sources.
+func (project *Project) GetCompileClasspath() ([]string, error) {
+	return project.Classpath(ScopeCompile, ScopeProvided)
+}
+
+// GetRuntimeClasspath returns the classpath used to run the project and to build fat jars.
+func (project *Project) GetRuntimeClasspath() ([]string, error) {
+	return project.Classpath(ScopeCompile, ScopeRuntime)
+}
+
+// GetTestClasspath returns the classpath used to compile and run tests.
+func (project *Project) GetTestClasspath() ([]string, error) {
+	return project.Classpath(ScopeCompile, ScopeProvided, ScopeRuntime, ScopeTest)
+}
+
+// GetProcessorPath returns the annotation processors passed to javac.
+func (project *Project) GetProcessorPath() ([]string, error) {
+	return project.Classpath(ScopeProcessor)
+}
@@ -0,0 +1,112 @@
+package lyra
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mrnavastar/assist/fs"
+)
+
+// Mirror redirects requests for other repositories to a different location, like an internal repository manager.
+type Mirror struct {
+	// Id names the credentials used for the mirror, see RepositoryCredentials
+	Id  string `json:",omitempty"`
+	URL string
+	// MirrorOf lists what is redirected. An entry is either a url prefix, whose remainder is appended to the mirror
+	// url, a host, whose whole path is appended, or * to mirror everything.
+	MirrorOf []string
+}
+
+// ProxySettings route requests through an http proxy. Without them the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables are used.
+type ProxySettings struct {
+	HTTP  string `json:",omitempty"`
+	HTTPS string `json:",omitempty"`
+	// NoProxy lists hosts, and domains starting with a dot, that are always contacted directly
+	NoProxy []string `json:",omitempty"`
+}
+
+// Settings are the user level settings from settings.json in the lyra config directory. They apply to every project.
+type Settings struct {
+	Mirrors []Mirror       `json:",omitempty"`
+	Proxy   *ProxySettings `json:",omitempty"`
+	// CABundle is a pem file with additional certificate authorities to trust
+	CABundle string `json:",omitempty"`
+}
+
+var settings Settings
+
+func init() {
+	if err := loadSettings(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadSettings() error {
+	config, err := GetConfig()
+	if err != nil {
+		return nil
+	}
+	file := path.Join(config, "settings.json")
+	if !fs.Exists(file) {
+		return nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("failed to read %s: %s", file, err)
+	}
+	for _, mirror := range settings.Mirrors {
+		if _, err := url.Parse(mirror.URL); err != nil || mirror.URL == "" {
+			return fmt.Errorf("failed to read %s: mirror %s has an invalid url", file, mirror.Id)
+		}
+	}
+	return nil
+}
+
+// GetSettings returns the user level settings.
+func GetSettings() Settings {
+	return settings
+}
+
+// MirrorFor returns where a url should actually be fetched from, and the mirror that applies to it if there is one.
+func (settings Settings) MirrorFor(uri *url.URL) (*url.URL, *Mirror) {
+	for i, mirror := range settings.Mirrors {
+		for _, pattern := range mirror.MirrorOf {
+			remainder, ok := "", false
+			switch {
+			case pattern == "*":
+				remainder, ok = uri.Path, true
+			case strings.Contains(pattern, "://"):
+				prefix := strings.TrimSuffix(pattern, "/")
+				full := uri.Scheme + "://" + uri.Host + uri.Path
+				if full == prefix || strings.HasPrefix(full, prefix+"/") {
+					remainder, ok = strings.TrimPrefix(full, prefix), true
+				}
+			default:
+				if strings.EqualFold(uri.Hostname(), pattern) || strings.EqualFold(uri.Host, pattern) {
+					remainder, ok = uri.Path, true
+				}
+			}
+			if !ok {
+				continue
+			}
+
+			location, err := url.Parse(strings.TrimSuffix(mirror.URL, "/") + remainder)
+			if err != nil {
+				continue
+			}
+			location.RawQuery = uri.RawQuery
+			return location, &settings.Mirrors[i]
+		}
+	}
+	return uri, nil
+}
@@ -0,0 +1,168 @@
+package lyra
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/mrnavastar/assist/fs"
+	"github.com/urfave/cli/v2"
+)
+
+// Substitution replaces every occurrence of an artifact in the dependency graph with another one, or with another
+// version of itself to force that version.
+type Substitution struct {
+	// Replace is a group:name pattern, * matches anything
+	Replace string
+	// With is the resolved replacement, along with its own dependencies
+	With Artifact
+}
+
+func init() {
+	Command.Register(&cli.Command{
+		Name:      "force",
+		Usage:     "force a version of an artifact, or replace it with another artifact everywhere in the graph",
+		ArgsUsage: "<group:name:version> | <group:name> <slug>",
+		Args:      true,
+		Action:    force,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "remove",
+				Usage: "stop forcing the given group:name",
+			},
+		},
+	})
+}
+
+// ValidatePattern checks a group:name pattern of an exclusion or substitution. A pattern without a name matches the
+// whole group.
+func ValidatePattern(pattern string) (string, error) {
+	if !strings.Contains(pattern, ":") {
+		pattern += ":*"
+	}
+	if strings.Count(pattern, ":") != 1 || strings.HasPrefix(pattern, ":") {
+		return "", errors.New("expected a group:name pattern but got " + pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return "", fmt.Errorf("invalid pattern %s: %s", pattern, err)
+	}
+	return pattern, nil
+}
+
+// Matches reports whether an artifact matches any of the group:name patterns.
+func (artifact Artifact) Matches(patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, artifact.Group+":"+artifact.Name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// substitute returns what an occurrence of an artifact is replaced with. The replacement takes over the scope and
+// exclusions of the occurrence, and the replacement itself is never replaced again.
+func substitute(artifact Artifact, substitutions []Substitution) (Artifact, bool) {
+	for _, substitution := range substitutions {
+		if !artifact.Matches([]string{substitution.Replace}) {
+			continue
+		}
+		with := substitution.With
+		if with.SameAs(artifact) && with.Version == artifact.Version {
+			return artifact, false
+		}
+		with.Scope = artifact.Scope
+		with.Exclude = artifact.Exclude
+		return with, true
+	}
+	return artifact, false
+}
+
+// Substitute resolves the replacement for an artifact and makes it replace every occurrence, see Substitution.
+func (project *Project) Substitute(replace string, slug string) error {
+	if !fs.Exists("lyra.json") {
+		return nil
+	}
+	replace, err := ValidatePattern(replace)
+	if err != nil {
+		return err
+	}
+
+	return project.parse(slug, nil, func(with Artifact) error {
+		for _, resolved := range Flatten([]Artifact{with}) {
+			if _, err := resolved.Resolve(); err != nil {
+				return err
+			}
+		}
+		project.modify(func(project *Project) {
+			for i, existing := range project.substitutions {
+				if existing.Replace == replace {
+					project.substitutions[i].With = with
+					return
+				}
+			}
+			project.substitutions = append(project.substitutions, Substitution{Replace: replace, With: with})
+		})
+		return nil
+	})
+}
+
+// RemoveSubstitution stops replacing an artifact. It returns false if nothing replaced it.
+func (project *Project) RemoveSubstitution(replace string) (removed bool) {
+	replace, err := ValidatePattern(replace)
+	if err != nil {
+		return false
+	}
+	project.modify(func(project *Project) {
+		project.substitutions = slices.DeleteFunc(project.substitutions, func(substitution Substitution) bool {
+			removed = removed || substitution.Replace == replace
+			return substitution.Replace == replace
+		})
+	})
+	return removed
+}
+
+// Exclusions returns the patterns excluded from the graph of a direct dependency.
+func (project *Project) Exclusions(artifact Artifact) []string {
+	project.mu.Lock()
+	defer project.mu.Unlock()
+	for _, existing := range project.artifacts {
+		if existing.SameAs(artifact) {
+			return existing.Exclude
+		}
+	}
+	return nil
+}
+
+func force(ctx *cli.Context) error {
+	if !fs.Exists("lyra.json") {
+		return errors.New("no project in current directory")
+	}
+	project := GetCurrentProject()
+	args := ctx.Args().Slice()
+
+	if ctx.Bool("remove") {
+		if len(args) == 0 {
+			return errors.New("please specify at least one group:name")
+		}
+		for _, replace := range args {
+			if !project.RemoveSubstitution(replace) {
+				return fmt.Errorf("%s is not forced", replace)
+			}
+		}
+		return nil
+	}
+
+	switch len(args) {
+	case 1:
+		artifact := Dependency.ParseMavenCoordinate(args[0])
+		if artifact.Version == "" {
+			return errors.New("please specify the version to force as group:name:version")
+		}
+		return project.Substitute(artifact.Group+":"+artifact.Name, args[0])
+	case 2:
+		return project.Substitute(args[0], args[1])
+	}
+	return errors.New("expected group:name:version, or the group:name to replace followed by its replacement")
+}
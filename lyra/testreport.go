@@ -0,0 +1,348 @@
+package lyra
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	fss "github.com/mrnavastar/assist/fs"
+)
+
+// Test statuses, tests that errored count as failed.
+const (
+	TestPassed  = "passed"
+	TestFailed  = "failed"
+	TestSkipped = "skipped"
+)
+
+// slowestTests is how many of the slowest tests the summary lists.
+const slowestTests = 5
+
+// TestReport is what lyra test found in the JUnit XML reports of every module, it is also written to
+// build/test-results/report.json.
+type TestReport struct {
+	Tests   int
+	Passed  int
+	Failed  int
+	Skipped int
+	// Time is the total duration in seconds
+	Time    float64
+	Classes []TestClass
+	Cases   []TestCase
+}
+
+// TestClass sums up the tests of one class.
+type TestClass struct {
+	Module  string
+	Class   string
+	Tests   int
+	Passed  int
+	Failed  int
+	Skipped int
+	Time    float64
+}
+
+// TestCase is a single test. Failures carry the stack trace trimmed to frames of the project, along with the source
+// file and line of the first of those frames.
+type TestCase struct {
+	Module  string
+	Class   string
+	Name    string
+	Status  string
+	Time    float64
+	Message string `json:",omitempty"`
+	Type    string `json:",omitempty"`
+	Trace   string `json:",omitempty"`
+	File    string `json:",omitempty"`
+	Line    int    `json:",omitempty"`
+}
+
+// junitSuite is a JUnit XML report, the root is either a testsuite or testsuites with nested suites.
+type junitSuite struct {
+	Cases  []junitCase  `xml:"testcase"`
+	Suites []junitSuite `xml:"testsuite"`
+}
+
+type junitCase struct {
+	Name    string        `xml:"name,attr"`
+	Class   string        `xml:"classname,attr"`
+	Time    string        `xml:"time,attr"`
+	Failure *junitFailure `xml:"failure"`
+	Error   *junitFailure `xml:"error"`
+	Skipped *junitFailure `xml:"skipped"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Trace   string `xml:",chardata"`
+}
+
+func (suite junitSuite) cases() []junitCase {
+	cases := suite.Cases
+	for _, nested := range suite.Suites {
+		cases = append(cases, nested.cases()...)
+	}
+	return cases
+}
+
+// ReadTestReport reads the reports the launcher wrote for each module.
+func ReadTestReport(modules []string) (report TestReport, err error) {
+	for _, module := range modules {
+		reports, err := filepath.Glob(path.Join(testReports, module, "TEST-*.xml"))
+		if err != nil {
+			return report, err
+		}
+		classes, err := projectClasses(module)
+		if err != nil {
+			return report, err
+		}
+
+		for _, file := range reports {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return report, err
+			}
+			suite := junitSuite{}
+			if err := xml.Unmarshal(data, &suite); err != nil {
+				return report, fmt.Errorf("failed to parse %s: %s", file, err)
+			}
+			for _, junit := range suite.cases() {
+				report.add(junit.testCase(module, classes))
+			}
+		}
+	}
+
+	slices.SortStableFunc(report.Classes, func(a TestClass, b TestClass) int {
+		return strings.Compare(a.Module+":"+a.Class, b.Module+":"+b.Class)
+	})
+	return report, nil
+}
+
+func (junit junitCase) testCase(module string, classes map[string]bool) TestCase {
+	test := TestCase{
+		Module: module,
+		Class:  junit.Class,
+		Name:   junit.Name,
+		Status: TestPassed,
+	}
+	test.Time, _ = strconv.ParseFloat(strings.ReplaceAll(junit.Time, ",", ""), 64)
+
+	failure := junit.Failure
+	if failure == nil {
+		failure = junit.Error
+	}
+	switch {
+	case failure != nil:
+		test.Status = TestFailed
+		test.Message = failure.Message
+		test.Type = failure.Type
+		test.Trace, test.File, test.Line = trimTrace(failure.Trace, module, classes)
+	case junit.Skipped != nil:
+		test.Status = TestSkipped
+		test.Message = junit.Skipped.Message
+	}
+	return test
+}
+
+func (report *TestReport) add(test TestCase) {
+	index := slices.IndexFunc(report.Classes, func(class TestClass) bool {
+		return class.Module == test.Module && class.Class == test.Class
+	})
+	if index == -1 {
+		index = len(report.Classes)
+		report.Classes = append(report.Classes, TestClass{Module: test.Module, Class: test.Class})
+	}
+	class := &report.Classes[index]
+
+	class.Tests++
+	report.Tests++
+	class.Time += test.Time
+	report.Time += test.Time
+	switch test.Status {
+	case TestPassed:
+		class.Passed++
+		report.Passed++
+	case TestFailed:
+		class.Failed++
+		report.Failed++
+	case TestSkipped:
+		class.Skipped++
+		report.Skipped++
+	}
+	report.Cases = append(report.Cases, test)
+}
+
+// projectClasses returns the name of every class compiled from the sources and tests of a module.
+func projectClasses(module string) (map[string]bool, error) {
+	classes := map[string]bool{}
+	for _, output := range []string{path.Join("build/output", module), path.Join(testOutput, module)} {
+		if !fss.Exists(output) {
+			continue
+		}
+		if err := walkMembers(output, func(file string, name string) {
+			if class, ok := strings.CutSuffix(name, ".class"); ok {
+				classes[strings.ReplaceAll(class, "/", ".")] = true
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return classes, nil
+}
+
+// trimTrace drops the stack frames of the JDK, JUnit and other libraries, runs of dropped frames are replaced by a
+// count. It also returns the source file and line of the first frame that belongs to the project.
+func trimTrace(trace string, module string, classes map[string]bool) (trimmed string, file string, line int) {
+	var lines []string
+	omitted := 0
+	flush := func() {
+		if omitted > 0 {
+			lines = append(lines, fmt.Sprintf("\t... %d library frames", omitted))
+			omitted = 0
+		}
+	}
+
+	for _, text := range strings.Split(strings.TrimSpace(trace), "\n") {
+		text = strings.TrimRight(text, "\r")
+		frame, ok := strings.CutPrefix(strings.TrimSpace(text), "at ")
+		if !ok {
+			// The java "... n more" lines refer to frames that are dropped anyway
+			if strings.HasPrefix(strings.TrimSpace(text), "...") {
+				continue
+			}
+			flush()
+			lines = append(lines, text)
+			continue
+		}
+
+		class, source, number := parseFrame(frame)
+		if !classes[class] && !classes[strings.SplitN(class, "$", 2)[0]] {
+			omitted++
+			continue
+		}
+		flush()
+		lines = append(lines, "\tat "+frame)
+		if file == "" && source != "" {
+			file, line = sourceFile(module, class, source), number
+		}
+	}
+	flush()
+	return strings.Join(lines, "\n"), file, line
+}
+
+// parseFrame splits a frame like java.base/java.util.List.of(List.java:12) into its class, file and line.
+func parseFrame(frame string) (class string, file string, line int) {
+	method, location, _ := strings.Cut(frame, "(")
+	if index := strings.LastIndex(method, "/"); index != -1 {
+		method = method[index+1:]
+	}
+	if index := strings.LastIndex(method, "."); index != -1 {
+		class = method[:index]
+	}
+	location = strings.TrimSuffix(location, ")")
+	file, number, ok := strings.Cut(location, ":")
+	if ok {
+		line, _ = strconv.Atoi(number)
+	}
+	return class, file, line
+}
+
+// sourceFile finds the source a class was compiled from, in the tests or the sources of its module.
+func sourceFile(module string, class string, file string) string {
+	dir := ""
+	if index := strings.LastIndex(class, "."); index != -1 {
+		dir = strings.ReplaceAll(class[:index], ".", "/")
+	}
+	roots := []string{path.Join("src", module, "java")}
+	if tests := TestSources(module); tests != "" {
+		roots = append([]string{path.Join(tests, "java")}, roots...)
+	}
+	for _, root := range roots {
+		if source := path.Join(root, dir, file); fss.Exists(source) {
+			return source
+		}
+	}
+	return path.Join(dir, file)
+}
+
+// Write saves the report as json.
+func (report TestReport) Write(file string) error {
+	data, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(file), fs.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, os.ModePerm)
+}
+
+// Print writes a table of every class, the slowest tests and every failure to stdout.
+func (report TestReport) Print() error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "MODULE\tCLASS\tTESTS\tPASSED\tFAILED\tSKIPPED\tTIME")
+	for _, class := range report.Classes {
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%d\t%d\t%d\t%s\n", class.Module, class.Class, class.Tests, class.Passed, class.Failed, class.Skipped, formatSeconds(class.Time))
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	slowest := slices.Clone(report.Cases)
+	slices.SortStableFunc(slowest, func(a TestCase, b TestCase) int {
+		switch {
+		case a.Time > b.Time:
+			return -1
+		case a.Time < b.Time:
+			return 1
+		}
+		return 0
+	})
+	if len(slowest) > slowestTests {
+		slowest = slowest[:slowestTests]
+	}
+	if len(slowest) > 0 && slowest[0].Time > 0 {
+		fmt.Println("\nslowest tests:")
+		writer = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, test := range slowest {
+			fmt.Fprintf(writer, "  %s > %s\t%s\n", test.Class, test.Name, formatSeconds(test.Time))
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+
+	for _, test := range report.Cases {
+		if test.Status != TestFailed {
+			continue
+		}
+		location := ""
+		if test.File != "" {
+			location = fmt.Sprintf(" (%s:%d)", test.File, test.Line)
+		}
+		fmt.Printf("\nFAILED %s > %s%s\n", test.Class, test.Name, location)
+		trace := test.Trace
+		if trace == "" {
+			trace = test.Type + ": " + test.Message
+		}
+		for _, line := range strings.Split(trace, "\n") {
+			fmt.Println("    " + line)
+		}
+	}
+
+	fmt.Printf("\n%d tests, %d passed, %d failed, %d skipped in %s\n", report.Tests, report.Passed, report.Failed, report.Skipped, formatSeconds(report.Time))
+	return nil
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64) + "s"
+}
@@ -0,0 +1,269 @@
+package lyra
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mrnavastar/assist/fs"
+	"github.com/urfave/cli/v2"
+)
+
+// junitVersion is the version of the JUnit Platform console launcher used when the project does not depend on one.
+const junitVersion = "1.11.3"
+
+var junitLauncher = Artifact{
+	Name:    "junit-platform-console-standalone",
+	Group:   "org.junit.platform",
+	Version: junitVersion,
+	Main:    fmt.Sprintf("%s/org/junit/platform/junit-platform-console-standalone/%s/junit-platform-console-standalone-%s.jar", MavenCentral, junitVersion, junitVersion),
+}
+
+const (
+	// testOutput is where the test classes of each module are compiled to
+	testOutput = "build/test-output"
+	// testReports is where the JUnit XML reports of each module are written to
+	testReports = "build/test-results"
+)
+
+func init() {
+	Command.Register(&cli.Command{
+		Name:      "test",
+		Usage:     "compile and run the tests of every module, or of the given modules",
+		ArgsUsage: "[module...]",
+		Args:      true,
+		Action:    test,
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:    "tests",
+				Aliases: []string{"t"},
+				Usage:   "only run the given classes, Class#method or class name patterns like *IntegrationTest",
+			},
+		},
+	})
+}
+
+type TestOptions struct {
+	// Modules limits the run to some modules, every module with tests runs if empty
+	Modules []string
+	// Filters are fully qualified classes, Class#method or glob patterns of class names
+	Filters []string
+	// JvmArgs are passed to the jvm the tests run in
+	JvmArgs []string
+}
+
+// TestSources returns the test source set of a module, which is src/<module>/test, or src/test for the main module.
+// Like a module it has a java and a resources directory. It returns an empty string if the module has no tests.
+func TestSources(module string) string {
+	if dir := path.Join("src", module, "test"); fs.Exists(dir) {
+		return dir
+	}
+	if module == "main" && fs.Exists("src/test") {
+		return "src/test"
+	}
+	return ""
+}
+
+// isTestModule reports whether a directory in src is the test source set of the main module.
+func isTestModule(name string) bool {
+	return name == "test" && fs.Exists("src/main")
+}
+
+// compileTests compiles the test source set of a module against the classes of the module and the test classpath.
+// Like the module itself, only the tests that changed are compiled again, but all of them are whenever the module was.
+// The tests of a module that has none left are removed, so they are not run anymore.
+func compileTests(module string, classpath []string, processorPath []string) error {
+	tests := TestSources(module)
+	if tests == "" || !fs.Exists(path.Join(tests, "java")) {
+		if err := os.RemoveAll(path.Join(testOutput, module)); err != nil {
+			return err
+		}
+		return os.RemoveAll(compileStateFile(module, true))
+	}
+	_, err := compileIncremental(path.Join(tests, "java"), compileStateFile(module, true), JavaCompileOptions{
+		Output:        path.Join(testOutput, module),
+		Classpath:     append([]string{path.Join("build/output", module)}, classpath...),
+		ProcessorPath: processorPath,
+	})
+	return err
+}
+
+func test(ctx *cli.Context) error {
+	if !fs.Exists("lyra.json") {
+		return errors.New("no project in current directory")
+	}
+	if err := Build.Project(BuildOptions{Tests: true}); err != nil {
+		return err
+	}
+	modules, err := testModules(ctx.Args().Slice())
+	if err != nil {
+		return err
+	}
+
+	options := TestOptions{
+		Modules: modules,
+		Filters: ctx.StringSlice("tests"),
+	}
+	for _, hook := range Test.Hooks.preTest {
+		if err := hook(ctx, &options); err != nil {
+			return err
+		}
+	}
+	if err := Test.Run(options); err != nil {
+		return err
+	}
+	for _, hook := range Test.Hooks.postTest {
+		if err := hook(ctx, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run runs the compiled tests of each module with the JUnit Platform console launcher, one module after the other.
+// The reports of a module are written to build/test-results/<module>, and summed up in build/test-results/report.json
+// once every module ran, see TestReport. It fails if any test failed.
+func (*TestAPI) Run(options TestOptions) error {
+	project := GetCurrentProject()
+	modules, err := testModules(options.Modules)
+	if err != nil {
+		return err
+	}
+	selectors, err := testSelectors(options.Filters)
+	if err != nil {
+		return err
+	}
+	classpath, err := project.GetTestClasspath()
+	if err != nil {
+		return err
+	}
+	launcher, err := testLauncher()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, module := range modules {
+		reports := path.Join(testReports, module)
+		if err := os.RemoveAll(reports); err != nil {
+			return err
+		}
+		args := []string{
+			"execute",
+			"--disable-banner",
+			// The summary is printed from the reports instead
+			"--details=none",
+			"--reports-dir", reports,
+			"--class-path", strings.Join(testClasspath(module, classpath), string(os.PathListSeparator)),
+		}
+		if len(selectors) == 0 || strings.HasPrefix(selectors[0], "--include-classname") {
+			args = append(args, "--scan-class-path", path.Join(testOutput, module))
+		}
+		if len(options.Filters) > 0 {
+			args = append(args, "--fail-if-no-tests")
+		}
+		args = append(args, selectors...)
+
+		if err := Java.Run(JavaRunOptions{Jar: launcher, JvmArgs: options.JvmArgs, ProgramArgs: args}); err != nil {
+			var exit *exec.ExitError
+			if !errors.As(err, &exit) {
+				return err
+			}
+			failed = append(failed, module)
+		}
+	}
+
+	report, err := ReadTestReport(modules)
+	if err != nil {
+		return err
+	}
+	if err := report.Write(path.Join(testReports, "report.json")); err != nil {
+		return err
+	}
+	if err := report.Print(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("tests failed in %s, see %s", strings.Join(failed, ", "), testReports)
+	}
+	return nil
+}
+
+// testModules returns the modules whose tests have been compiled, or checks that the requested ones have.
+func testModules(requested []string) (modules []string, err error) {
+	if len(requested) > 0 {
+		for _, module := range requested {
+			if !fs.Exists(path.Join(testOutput, module)) {
+				return nil, fmt.Errorf("module %s has no tests", module)
+			}
+		}
+		return requested, nil
+	}
+
+	entries, err := os.ReadDir("src")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !isTestModule(entry.Name()) && fs.Exists(path.Join(testOutput, entry.Name())) {
+			modules = append(modules, entry.Name())
+		}
+	}
+	if len(modules) == 0 {
+		return nil, errors.New("no tests found, put them in src/<module>/test/java or src/test/java")
+	}
+	return modules, nil
+}
+
+// testClasspath puts the test classes and resources of a module in front of the module itself and its dependencies.
+func testClasspath(module string, dependencies []string) (classpath []string) {
+	tests := TestSources(module)
+	for _, entry := range []string{
+		path.Join(testOutput, module),
+		path.Join(tests, "resources"),
+		path.Join("build/output", module),
+		path.Join("src", module, "resources"),
+	} {
+		if fs.Exists(entry) {
+			classpath = append(classpath, entry)
+		}
+	}
+	return append(classpath, dependencies...)
+}
+
+// testSelectors turns filters into launcher arguments. Classes and methods are selected directly, while patterns
+// filter the classes found by scanning the test classes. The two can't be mixed.
+func testSelectors(filters []string) (args []string, err error) {
+	var patterns []string
+	for _, filter := range filters {
+		switch {
+		case strings.ContainsAny(filter, "*?"):
+			pattern := regexp.QuoteMeta(filter)
+			pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+			pattern = strings.ReplaceAll(pattern, `\?`, ".")
+			patterns = append(patterns, "--include-classname", "^"+pattern+"$")
+		case strings.Contains(filter, "#"):
+			args = append(args, "--select-method", filter)
+		default:
+			args = append(args, "--select-class", filter)
+		}
+	}
+	if len(args) > 0 && len(patterns) > 0 {
+		return nil, errors.New("class name patterns can't be combined with classes or methods")
+	}
+	return append(patterns, args...), nil
+}
+
+// testLauncher resolves the console launcher, a launcher the project depends on itself wins over the default one.
+func testLauncher() (string, error) {
+	for _, artifact := range Flatten(GetCurrentProject().Dependencies()) {
+		if artifact.SameAs(junitLauncher) {
+			return artifact.Resolve()
+		}
+	}
+	return junitLauncher.Resolve()
+}
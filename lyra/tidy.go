@@ -0,0 +1,375 @@
+package lyra
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	fss "github.com/mrnavastar/assist/fs"
+	"github.com/mrnavastar/babe/babe"
+	"github.com/urfave/cli/v2"
+)
+
+// jdkPackages are always provided by the runtime, so references to them never need a dependency. Only the javax
+// packages of the JDK itself are listed, others like javax/inject or javax/annotation come from libraries.
+var jdkPackages = []string{
+	"java/", "jdk/", "sun/", "com/sun/", "netscape/javascript/",
+	"javax/accessibility/", "javax/annotation/processing/", "javax/crypto/", "javax/imageio/", "javax/lang/model/",
+	"javax/management/", "javax/naming/", "javax/net/", "javax/print/", "javax/rmi/ssl/", "javax/script/",
+	"javax/security/auth/", "javax/security/cert/", "javax/security/sasl/", "javax/smartcardio/", "javax/sound/",
+	"javax/sql/", "javax/swing/", "javax/tools/", "javax/transaction/xa/",
+	"javax/xml/XMLConstants", "javax/xml/catalog/", "javax/xml/crypto/", "javax/xml/datatype/", "javax/xml/namespace/",
+	"javax/xml/parsers/", "javax/xml/stream/", "javax/xml/transform/", "javax/xml/validation/", "javax/xml/xpath/",
+	"org/ietf/jgss/", "org/w3c/dom/", "org/xml/sax/",
+}
+
+func init() {
+	Command.Register(&cli.Command{
+		Name:   "tidy",
+		Args:   false,
+		Action: tidy,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "check",
+				Usage: "report what would change and exit with a non-zero code instead of rewriting lyra.json",
+			},
+		},
+	})
+}
+
+// FindReferencedClasses returns every class referenced from the constant pools of the class files in a directory.
+func FindReferencedClasses(directory string) (referenced map[string]bool, defined map[string]bool, err error) {
+	referenced = map[string]bool{}
+	defined = map[string]bool{}
+	if !fss.Exists(directory) {
+		return referenced, defined, nil
+	}
+
+	err = filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".class") {
+			return nil
+		}
+
+		member, err := babe.JarMemberFromFile(path)
+		if err != nil {
+			return err
+		}
+		class, err := member.GetAsClass()
+		if err != nil {
+			return err
+		}
+
+		defined[newConstantPool(&class).className(class.ThisClass)] = true
+		for _, name := range classReferences(&class) {
+			referenced[name] = true
+		}
+		return nil
+	})
+	return referenced, defined, err
+}
+
+// classReferences returns every class named in the constant pool of a class.
+func classReferences(class *babe.Class) (referenced []string) {
+	pool := newConstantPool(class)
+	for _, constant := range pool {
+		if info, ok := constant.(*babe.ClassInfo); ok {
+			if name := classFromDescriptor(pool.utf8(info.NameIndex)); name != "" {
+				referenced = append(referenced, name)
+			}
+		}
+	}
+	return referenced
+}
+
+// constantPool holds the constants of a class at the indexes the class file refers to them by. babe keeps long and
+// double constants in a single entry although they take up two, which shifts every index after them.
+type constantPool []babe.Info
+
+func newConstantPool(class *babe.Class) constantPool {
+	pool := constantPool{nil}
+	for _, constant := range class.ConstantPool {
+		pool = append(pool, constant)
+		switch constant.(type) {
+		case *babe.LongInfo, *babe.DoubleInfo:
+			pool = append(pool, nil)
+		}
+	}
+	return pool
+}
+
+func (pool constantPool) get(index uint16) babe.Info {
+	if int(index) >= len(pool) {
+		return nil
+	}
+	return pool[index]
+}
+
+// utf8 returns the string at an index, or an empty string if there is none.
+func (pool constantPool) utf8(index uint16) string {
+	if info, ok := pool.get(index).(*babe.Utf8Info); ok {
+		return info.String()
+	}
+	return ""
+}
+
+// className returns the name of the class at an index, or an empty string if there is none.
+func (pool constantPool) className(index uint16) string {
+	if info, ok := pool.get(index).(*babe.ClassInfo); ok {
+		return pool.utf8(info.NameIndex)
+	}
+	return ""
+}
+
+// classFromDescriptor strips array markers from a constant pool class name, primitive arrays have no class.
+func classFromDescriptor(name string) string {
+	if !strings.HasPrefix(name, "[") {
+		return name
+	}
+	name = strings.TrimLeft(name, "[")
+	if !strings.HasPrefix(name, "L") {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(name, "L"), ";")
+}
+
+// jarClasses lists the classes contained in a jar, or a directory of classes, without reading them.
+func jarClasses(jar string) (classes []string, err error) {
+	if info, err := os.Stat(jar); err == nil && info.IsDir() {
+		err = filepath.WalkDir(jar, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".class") {
+				return err
+			}
+			name, err := filepath.Rel(jar, path)
+			classes = append(classes, strings.TrimSuffix(filepath.ToSlash(name), ".class"))
+			return err
+		})
+		return classes, err
+	}
+
+	reader, err := zip.OpenReader(jar)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if strings.HasSuffix(file.Name, ".class") {
+			classes = append(classes, strings.TrimSuffix(file.Name, ".class"))
+		}
+	}
+	return classes, nil
+}
+
+// urlCoordinate recovers a maven coordinate from a url in one of the given repositories, following the standard
+// repository layout of group/name/version/name-version.jar.
+func urlCoordinate(uri string, repos []Repository) (string, bool) {
+	for _, repo := range repos {
+		prefix := strings.TrimSuffix(repo.URL, "/") + "/"
+		if !strings.HasPrefix(uri, prefix) {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimPrefix(uri, prefix), "/")
+		if len(parts) < 4 {
+			continue
+		}
+		file, version, name := parts[len(parts)-1], parts[len(parts)-2], parts[len(parts)-3]
+		if file != name+"-"+version+".jar" {
+			continue
+		}
+		return strings.Join(parts[:len(parts)-3], ".") + ":" + name + ":" + version, true
+	}
+	return "", false
+}
+
+// findInCache looks for cached jars from the given repositories that provide any of the given classes. When several
+// versions of an artifact are cached the newest one is picked.
+func findInCache(classes []string, repos []Repository) (coordinates []string, err error) {
+	entries, err := Cache.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		uri        string
+		coordinate string
+	}
+	var candidates []candidate
+	for uri := range entries {
+		if coordinate, ok := urlCoordinate(uri, repos); ok {
+			candidates = append(candidates, candidate{uri: uri, coordinate: coordinate})
+		}
+	}
+	slices.SortFunc(candidates, func(a candidate, b candidate) int {
+		keyA, versionA := cutVersion(a.coordinate)
+		keyB, versionB := cutVersion(b.coordinate)
+		if order := strings.Compare(keyA, keyB); order != 0 {
+			return order
+		}
+		if order := CompareVersions(versionB, versionA); order != 0 {
+			return order
+		}
+		return strings.Compare(a.uri, b.uri)
+	})
+
+	remaining := map[string]bool{}
+	for _, class := range classes {
+		remaining[class] = true
+	}
+	for _, candidate := range candidates {
+		if len(remaining) == 0 {
+			break
+		}
+		jar, ok, err := Cache.Lookup(candidate.uri)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		provided, err := jarClasses(jar)
+		if err != nil {
+			continue
+		}
+		found := false
+		for _, class := range provided {
+			if remaining[class] {
+				delete(remaining, class)
+				found = true
+			}
+		}
+		if found && !slices.Contains(coordinates, candidate.coordinate) {
+			coordinates = append(coordinates, candidate.coordinate)
+		}
+	}
+	slices.Sort(coordinates)
+	return coordinates, nil
+}
+
+// transitiveArtifact returns what the classpath would hold for a direct dependency if it was not declared, as picked by
+// mediation between the other dependencies.
+func transitiveArtifact(artifacts []Artifact, direct Artifact) (Artifact, bool) {
+	others := slices.DeleteFunc(slices.Clone(artifacts), direct.SameAs)
+	for _, artifact := range Flatten(others) {
+		if artifact.SameAs(direct) && artifact.Scope != ScopeProcessor {
+			return artifact, true
+		}
+	}
+	return Artifact{}, false
+}
+
+// cutVersion splits a group:name:version coordinate into its group:name and its version.
+func cutVersion(coordinate string) (key string, version string) {
+	index := strings.LastIndex(coordinate, ":")
+	return coordinate[:index], coordinate[index+1:]
+}
+
+func tidy(ctx *cli.Context) error {
+	if !fss.Exists("lyra.json") {
+		return errors.New("no project in current directory")
+	}
+	project := GetCurrentProject()
+	check := ctx.Bool("check")
+
+	referenced, defined, err := FindReferencedClasses("build/output")
+	if err != nil {
+		return err
+	}
+	if len(defined) == 0 {
+		return errors.New("no compiled classes found, run lyra build first")
+	}
+
+	// Map every class on the classpath to the artifact that provides it
+	providers := map[string]string{}
+	for _, artifact := range Flatten(project.Dependencies()) {
+		if artifact.Scope == ScopeProcessor {
+			continue
+		}
+		resolved, err := artifact.Resolve()
+		if err != nil {
+			return err
+		}
+		if resolved == "" {
+			continue
+		}
+		jar, err := artifact.classpathEntry(resolved)
+		if err != nil {
+			return err
+		}
+		if jar == "" {
+			continue
+		}
+		classes, err := jarClasses(jar)
+		if err != nil {
+			return err
+		}
+		for _, class := range classes {
+			providers[class] = artifact.Key()
+		}
+	}
+
+	used := map[string]bool{}
+	var missing []string
+	for class := range referenced {
+		if defined[class] || slices.ContainsFunc(jdkPackages, func(prefix string) bool { return strings.HasPrefix(class, prefix) }) {
+			continue
+		}
+		if provider, ok := providers[class]; ok {
+			used[provider] = true
+			continue
+		}
+		missing = append(missing, class)
+	}
+	slices.Sort(missing)
+
+	var findings []string
+
+	// Direct entries that no class references are stale when another dependency already pulls in the same version.
+	// Entries that pin another version than the transitive one change the graph, so they are left alone.
+	for _, artifact := range project.Dependencies() {
+		key := artifact.Key()
+		if !used[key] && artifact.EffectiveScope() == ScopeCompile {
+			if transitive, ok := transitiveArtifact(project.Dependencies(), artifact); ok && transitive.Version == artifact.Version && transitive.Scope == ScopeCompile {
+				findings = append(findings, "stale: "+key+" is already a transitive dependency")
+				if !check {
+					project.RemoveDependency(artifact)
+				}
+				continue
+			}
+		}
+		// Runtime, test and processor dependencies are never referenced from main classes
+		if !used[key] && artifact.EffectiveScope() == ScopeCompile {
+			findings = append(findings, "unused: "+key+" is not referenced by any class")
+		}
+	}
+
+	coordinates, err := findInCache(missing, project.Repos())
+	if err != nil {
+		return err
+	}
+	for _, coordinate := range coordinates {
+		findings = append(findings, "missing: "+coordinate+" provides referenced classes")
+		if !check {
+			if err := project.Get(coordinate, ScopeCompile); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, finding := range findings {
+		println(finding)
+	}
+	if check && len(findings) > 0 {
+		return cli.Exit(fmt.Sprintf("lyra.json is not tidy (%d issues)", len(findings)), 1)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package lyra
+
+import (
+	"math/big"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// qualifiers orders the well known version qualifiers, a release has the empty qualifier. Unknown qualifiers sort
+// after all of these, and alphabetically between themselves.
+var qualifiers = []string{"alpha", "beta", "milestone", "rc", "snapshot", "", "sp"}
+
+var qualifierAliases = map[string]string{
+	"a":       "alpha",
+	"b":       "beta",
+	"m":       "milestone",
+	"cr":      "rc",
+	"ga":      "",
+	"final":   "",
+	"release": "",
+}
+
+type versionToken struct {
+	number    *big.Int
+	qualifier string
+}
+
+// tokenizeVersion splits a version into numbers and qualifiers at dots, dashes and transitions between digits and
+// letters. Trailing release markers (zeros, ga, final) are dropped so that 1.0 and 1 compare equal.
+func tokenizeVersion(version string) (tokens []versionToken) {
+	var parts []string
+	current := ""
+	for _, r := range strings.ToLower(version) {
+		if r == '.' || r == '-' || r == '_' || r == '+' {
+			parts = append(parts, current)
+			current = ""
+			continue
+		}
+		if current != "" && unicode.IsDigit(r) != unicode.IsDigit(rune(current[len(current)-1])) {
+			parts = append(parts, current)
+			current = ""
+		}
+		current += string(r)
+	}
+	parts = append(parts, current)
+
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		if number, ok := new(big.Int).SetString(part, 10); ok {
+			tokens = append(tokens, versionToken{number: number})
+			continue
+		}
+		// Single letter aliases only apply when directly followed by a number, like 1.0a1
+		if alias, ok := qualifierAliases[part]; ok && (len(part) > 1 || (i+1 < len(parts) && parts[i+1] != "" && unicode.IsDigit(rune(parts[i+1][0])))) {
+			part = alias
+		}
+		// Zeros before a qualifier don't matter either, 1.0-rc1 is the same as 1-rc1
+		for len(tokens) > 0 && tokens[len(tokens)-1].number != nil && tokens[len(tokens)-1].number.Sign() == 0 {
+			tokens = tokens[:len(tokens)-1]
+		}
+		tokens = append(tokens, versionToken{qualifier: part})
+	}
+
+	for len(tokens) > 0 {
+		last := tokens[len(tokens)-1]
+		if (last.number != nil && last.number.Sign() == 0) || (last.number == nil && last.qualifier == "") {
+			tokens = tokens[:len(tokens)-1]
+			continue
+		}
+		break
+	}
+	return tokens
+}
+
+func compareQualifiers(a string, b string) int {
+	rankA, rankB := slices.Index(qualifiers, a), slices.Index(qualifiers, b)
+	if rankA == -1 && rankB == -1 {
+		return strings.Compare(a, b)
+	}
+	if rankA == -1 {
+		return 1
+	}
+	if rankB == -1 {
+		return -1
+	}
+	return rankA - rankB
+}
+
+func compareTokens(a versionToken, b versionToken) int {
+	switch {
+	case a.number != nil && b.number != nil:
+		return a.number.Cmp(b.number)
+	case a.number != nil:
+		// Numbers are always newer than qualifiers, 1.1 > 1-sp
+		return 1
+	case b.number != nil:
+		return -1
+	}
+	return compareQualifiers(a.qualifier, b.qualifier)
+}
+
+// CompareVersions orders two versions following Maven's rules: numbers compare numerically, alpha < beta <
+// milestone < rc < snapshot < release < sp, and a missing part is treated as a release. It returns a negative
+// number if a is older than b, zero if they are equal and a positive number if a is newer.
+func CompareVersions(a string, b string) int {
+	tokensA, tokensB := tokenizeVersion(a), tokenizeVersion(b)
+	for i := 0; i < max(len(tokensA), len(tokensB)); i++ {
+		var tokenA, tokenB versionToken
+		if i < len(tokensA) {
+			tokenA = tokensA[i]
+		} else {
+			tokenA = paddingFor(tokensB[i])
+		}
+		if i < len(tokensB) {
+			tokenB = tokensB[i]
+		} else {
+			tokenB = paddingFor(tokensA[i])
+		}
+
+		if result := compareTokens(tokenA, tokenB); result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// paddingFor returns what a shorter version is compared with, a zero against numbers and a release otherwise.
+func paddingFor(token versionToken) versionToken {
+	if token.number != nil {
+		return versionToken{number: big.NewInt(0)}
+	}
+	return versionToken{}
+}
+
+// IsPreRelease reports whether a version carries a qualifier that sorts before a release, like 2.0-RC1 or
+// 1.0-SNAPSHOT.
+func IsPreRelease(version string) bool {
+	for _, token := range tokenizeVersion(version) {
+		if token.number == nil && compareQualifiers(token.qualifier, "") < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSnapshot reports whether a version is a maven snapshot, which can change after it was resolved.
+func IsSnapshot(version string) bool {
+	return strings.HasSuffix(version, "-SNAPSHOT")
+}
+
+// VersionPrefix returns the first count numeric parts of a version, e.g. the major version for a count of 1.
+func VersionPrefix(version string, count int) []string {
+	var prefix []string
+	for _, token := range tokenizeVersion(version) {
+		if token.number == nil || len(prefix) == count {
+			break
+		}
+		prefix = append(prefix, token.number.String())
+	}
+	for len(prefix) < count {
+		prefix = append(prefix, "0")
+	}
+	return prefix
+}
+
+// LatestVersion returns the newest release out of versions whose first count numeric parts match the given version,
+// a count of zero accepts any release.
+func LatestVersion(versions []string, version string, count int) string {
+	latest := ""
+	for _, candidate := range versions {
+		if IsPreRelease(candidate) || !slices.Equal(VersionPrefix(candidate, count), VersionPrefix(version, count)) {
+			continue
+		}
+		if latest == "" || CompareVersions(candidate, latest) > 0 {
+			latest = candidate
+		}
+	}
+	return latest
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 )
 
 func main() {
@@ -27,6 +28,10 @@ func main() {
 		log.Fatal("no JDK present on system")
 	}
 
+	if missing, err := missingPlugins(); err == nil && len(missing) > 0 {
+		log.Printf("warning: this project requires plugins that are not installed, run: lyra plugin get %s", strings.Join(missing, " "))
+	}
+
 	if err := lyra.Command.Run(os.Args...); err != nil {
 		log.Fatal(err)
 	}
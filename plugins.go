@@ -3,5 +3,6 @@ package main
 // Default plugins
 import _ "github.com/mrnavastar/lyra/plugins/mvn"
 import _ "github.com/mrnavastar/lyra/plugins/application"
+import _ "github.com/mrnavastar/lyra/plugins/coverage"
 
 import _ "github.com/mrnavastar/lyra/plugins/minecraft"
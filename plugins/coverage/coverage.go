@@ -0,0 +1,229 @@
+// Package coverage measures which code the tests of a project run with JaCoCo. lyra test --coverage attaches the
+// JaCoCo agent to the tests and writes an HTML and XML report for every module to build/coverage, along with a
+// summary in the terminal. Minimum coverage can be enforced with --min-coverage.
+package coverage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mrnavastar/assist/fs"
+	"github.com/mrnavastar/lyra/lyra"
+	"github.com/urfave/cli/v2"
+)
+
+const jacocoVersion = "0.8.12"
+
+var jacocoAgent = lyra.Artifact{
+	Name:       "org.jacoco.agent",
+	Group:      "org.jacoco",
+	Version:    jacocoVersion,
+	Classifier: "runtime",
+	Main:       fmt.Sprintf("%s/org/jacoco/org.jacoco.agent/%s/org.jacoco.agent-%s-runtime.jar", lyra.MavenCentral, jacocoVersion, jacocoVersion),
+}
+
+var jacocoCli = lyra.Artifact{
+	Name:       "org.jacoco.cli",
+	Group:      "org.jacoco",
+	Version:    jacocoVersion,
+	Classifier: "nodeps",
+	Main:       fmt.Sprintf("%s/org/jacoco/org.jacoco.cli/%s/org.jacoco.cli-%s-nodeps.jar", lyra.MavenCentral, jacocoVersion, jacocoVersion),
+}
+
+const (
+	// coverageDir holds the execution data and the reports of every module
+	coverageDir = "build/coverage"
+	// executionData is what the agent records while the tests of every module run
+	executionData = "build/coverage/jacoco.exec"
+)
+
+// counters are the JaCoCo counters in the order the summary shows them.
+var counters = []string{"INSTRUCTION", "BRANCH", "LINE", "METHOD", "CLASS"}
+
+func minCoverageFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:  "min-coverage",
+		Usage: "fail unless every module reaches a coverage like line=80 or branch=60, a bare percentage is line coverage",
+	}
+}
+
+func init() {
+	lyra.Command.AddFlags("test",
+		&cli.BoolFlag{
+			Name:  "coverage",
+			Usage: "measure code coverage with JaCoCo and write reports to " + coverageDir,
+		},
+		minCoverageFlag(),
+	)
+
+	lyra.Command.Register(&cli.Command{
+		Name:      "coverage",
+		Usage:     "write the coverage reports of the last lyra test --coverage again",
+		ArgsUsage: "[module...]",
+		Args:      true,
+		Action: func(ctx *cli.Context) error {
+			if !fs.Exists(executionData) {
+				return errors.New("no coverage recorded yet, run lyra test --coverage first")
+			}
+			modules := ctx.Args().Slice()
+			if len(modules) == 0 {
+				entries, err := os.ReadDir("build/output")
+				if err != nil {
+					return err
+				}
+				for _, entry := range entries {
+					if entry.IsDir() {
+						modules = append(modules, entry.Name())
+					}
+				}
+			}
+			return report(modules, ctx.StringSlice("min-coverage"))
+		},
+		Flags: []cli.Flag{minCoverageFlag()},
+	})
+
+	lyra.Test.Hooks.PreTest(func(ctx *cli.Context, options *lyra.TestOptions) error {
+		if !ctx.Bool("coverage") {
+			return nil
+		}
+		if _, err := parseThresholds(ctx.StringSlice("min-coverage")); err != nil {
+			return err
+		}
+		agent, err := jacocoAgent.Resolve()
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(coverageDir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(coverageDir, os.ModePerm); err != nil {
+			return err
+		}
+		// Every module appends to the same file, the reports only pick the classes of their module out of it
+		options.JvmArgs = append(options.JvmArgs, fmt.Sprintf("-javaagent:%s=destfile=%s,append=true", agent, executionData))
+		return nil
+	})
+
+	lyra.Test.Hooks.PostTest(func(ctx *cli.Context, options lyra.TestOptions) error {
+		if !ctx.Bool("coverage") {
+			return nil
+		}
+		return report(options.Modules, ctx.StringSlice("min-coverage"))
+	})
+}
+
+// jacocoReport is the part of a JaCoCo XML report the summary needs, the counters of the whole report.
+type jacocoReport struct {
+	Counters []struct {
+		Type    string `xml:"type,attr"`
+		Missed  int    `xml:"missed,attr"`
+		Covered int    `xml:"covered,attr"`
+	} `xml:"counter"`
+}
+
+// coverage returns the covered percentage of every counter.
+func (report jacocoReport) coverage() map[string]float64 {
+	coverage := map[string]float64{}
+	for _, counter := range report.Counters {
+		if total := counter.Missed + counter.Covered; total > 0 {
+			coverage[counter.Type] = 100 * float64(counter.Covered) / float64(total)
+		}
+	}
+	return coverage
+}
+
+// parseThresholds reads --min-coverage values like line=80 into the counter they apply to.
+func parseThresholds(values []string) (map[string]float64, error) {
+	thresholds := map[string]float64{}
+	for _, value := range values {
+		counter, percentage, ok := strings.Cut(value, "=")
+		if !ok {
+			counter, percentage = "line", value
+		}
+		counter = strings.ToUpper(counter)
+		if !slices.Contains(counters, counter) {
+			return nil, fmt.Errorf("unknown coverage counter %s, expected one of %s", counter, strings.ToLower(strings.Join(counters, ", ")))
+		}
+		minimum, err := strconv.ParseFloat(strings.TrimSuffix(percentage, "%"), 64)
+		if err != nil || minimum < 0 || minimum > 100 {
+			return nil, fmt.Errorf("invalid minimum coverage %s", value)
+		}
+		thresholds[counter] = minimum
+	}
+	return thresholds, nil
+}
+
+// report writes the HTML and XML report of every module with JaCoCo, prints a summary and checks the thresholds.
+func report(modules []string, minimums []string) error {
+	thresholds, err := parseThresholds(minimums)
+	if err != nil {
+		return err
+	}
+	jar, err := jacocoCli.Resolve()
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "MODULE\t"+strings.Join(counters, "\t"))
+	var failures []string
+	for _, module := range modules {
+		classes := path.Join("build/output", module)
+		if !fs.Exists(classes) {
+			continue
+		}
+		output := path.Join(coverageDir, module)
+		args := []string{"report", executionData, "--classfiles", classes, "--name", module,
+			"--html", path.Join(output, "html"), "--xml", path.Join(output, "coverage.xml"), "--quiet"}
+		if sources := path.Join("src", module, "java"); fs.Exists(sources) {
+			args = append(args, "--sourcefiles", sources)
+		}
+		if err := lyra.Java.Run(lyra.JavaRunOptions{Jar: jar, ProgramArgs: args}); err != nil {
+			return fmt.Errorf("failed to write the coverage report of %s: %s", module, err)
+		}
+
+		data, err := os.ReadFile(path.Join(output, "coverage.xml"))
+		if err != nil {
+			return err
+		}
+		parsed := jacocoReport{}
+		if err := xml.Unmarshal(data, &parsed); err != nil {
+			return fmt.Errorf("failed to parse the coverage report of %s: %s", module, err)
+		}
+
+		coverage := parsed.coverage()
+		row := module
+		for _, counter := range counters {
+			value, ok := coverage[counter]
+			if !ok {
+				row += "\t-"
+				continue
+			}
+			row += fmt.Sprintf("\t%.1f%%", value)
+		}
+		fmt.Fprintln(writer, row)
+
+		for _, counter := range counters {
+			minimum, ok := thresholds[counter]
+			if ok && coverage[counter] < minimum {
+				failures = append(failures, fmt.Sprintf("%s %s coverage is %.1f%%, below the minimum of %.1f%%", module, strings.ToLower(counter), coverage[counter], minimum))
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	fmt.Println("reports written to " + coverageDir)
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "\n"))
+	}
+	return nil
+}
@@ -3,9 +3,9 @@ package minecraft
 import (
 	"fmt"
 	"github.com/mrnavastar/assist/fs"
-	"github.com/mrnavastar/assist/web"
 	"github.com/mrnavastar/lyra/lyra"
 	"net/url"
+	"os"
 	"path"
 	"strings"
 )
@@ -59,7 +59,7 @@ func resolveMinecraft(uri *url.URL) (string, error) {
 		return "", err
 	}
 	mojmapPath := path.Join(cache, "minecraft", mojmapUrl.Path)
-	if err := web.Download(mojmapPath, mojmapUrl.String()); err != nil {
+	if err := downloadVerified(mojmapPath, mojmapUrl.String(), uri.Query().Get("mojmapSha1")); err != nil {
 		return "", err
 	}
 
@@ -69,7 +69,10 @@ func resolveMinecraft(uri *url.URL) (string, error) {
 		return "file://" + remappedJar, nil
 	}
 
-	if err := web.Download(minecraftJar, strings.Replace(uri.String(), "minecraft", "https", 1)); err != nil {
+	jarUrl := *uri
+	jarUrl.Scheme = "https"
+	jarUrl.RawQuery = ""
+	if err := downloadVerified(minecraftJar, jarUrl.String(), uri.Query().Get("sha1")); err != nil {
 		return "", err
 	}
 	if err := RemapJar(minecraftJar, mojmapPath, true); err != nil {
@@ -77,3 +80,22 @@ func resolveMinecraft(uri *url.URL) (string, error) {
 	}
 	return "file://" + remappedJar, nil
 }
+
+// downloadVerified downloads a file and checks it against the sha1 mojang published for it, a bad file is deleted
+// again so that it is not picked up by the next run.
+func downloadVerified(file string, uri string, sha1 string) error {
+	if fs.Exists(file) {
+		return nil
+	}
+	if err := lyra.DownloadFile(file, uri); err != nil {
+		return err
+	}
+	if sha1 == "" {
+		return nil
+	}
+	if err := lyra.VerifyFile(file, uri, "sha1", sha1); err != nil {
+		os.Remove(file)
+		return err
+	}
+	return nil
+}
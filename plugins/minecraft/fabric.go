@@ -1,7 +1,6 @@
 package minecraft
 
 import (
-	"github.com/mrnavastar/assist/web"
 	"github.com/mrnavastar/lyra/lyra"
 	"path"
 	"strings"
@@ -59,11 +58,11 @@ type FabricLoaderVersion struct {
 
 func GetLatestFabricVersion(version MinecraftVersion) (fabricLoaderMetaVersion FabricLoaderVersion, err error) {
 	var fabricLoaderMeta FabricLoaderMeta
-	if err := web.GetJson(path.Join(fabricMetaUrl, "v2/versions/loader"), &fabricLoaderMeta); err != nil {
+	if err := lyra.GetJson(fabricMetaUrl+"v2/versions/loader", &fabricLoaderMeta); err != nil {
 		return FabricLoaderVersion{}, err
 	}
 
-	if err := web.GetJson(path.Join(fabricMetaUrl, "v2/versions/loader", version.ID, fabricLoaderMeta[0].Version), &fabricLoaderMetaVersion); err != nil {
+	if err := lyra.GetJson(fabricMetaUrl+path.Join("v2/versions/loader", version.ID, fabricLoaderMeta[0].Version), &fabricLoaderMetaVersion); err != nil {
 		return FabricLoaderVersion{}, err
 	}
 	return fabricLoaderMetaVersion, nil
@@ -72,7 +71,9 @@ func GetLatestFabricVersion(version MinecraftVersion) (fabricLoaderMetaVersion F
 func convertFabricLibraries(libs []fabricLibrary) (artifacts []lyra.Artifact) {
 	for _, library := range libs {
 		artifact := lyra.Dependency.ParseMavenCoordinate(library.Name)
-		artifact.Main = path.Join(library.URL, path.Join(strings.Split(artifact.Group, ".")...), artifact.Name, artifact.Version, artifact.Name+"-"+artifact.Version+".jar")
+		artifact.Main = strings.TrimSuffix(library.URL, "/") + "/" + path.Join(path.Join(strings.Split(artifact.Group, ".")...), artifact.Name, artifact.Version, artifact.Name+"-"+artifact.Version+".jar")
+		artifact.Sha1 = library.Sha1
+		artifact.Sha256 = library.Sha256
 		artifacts = append(artifacts, artifact)
 	}
 	return artifacts
@@ -104,7 +105,7 @@ func (version FabricLoaderVersion) GetLibraries() (artifacts []lyra.Artifact) {
 
 func (version FabricLoaderVersion) GetLoader() lyra.Artifact {
 	artifact := lyra.Dependency.ParseMavenCoordinate(version.Loader.Maven)
-	artifact.Main = path.Join(fabricMavenUrl, path.Join(strings.Split(artifact.Group, ".")...), artifact.Name, artifact.Version, artifact.Name+"-"+artifact.Version+".jar")
+	artifact.Main = fabricMavenUrl + path.Join(path.Join(strings.Split(artifact.Group, ".")...), artifact.Name, artifact.Version, artifact.Name+"-"+artifact.Version+".jar")
 	artifact.Sources = strings.Replace(artifact.Main, ".jar", "-sources.jar", 1)
 	artifact.Docs = strings.Replace(artifact.Main, ".jar", "-javadoc.jar", 1)
 	return artifact
@@ -1,6 +1,7 @@
 package minecraft
 
 import (
+	"errors"
 	"github.com/mrnavastar/lyra/lyra"
 	"github.com/urfave/cli/v2"
 	"strings"
@@ -29,7 +30,7 @@ func init() {
 func minecraftParser(slug string) (lyra.Artifact, error) {
 	artifact := lyra.Dependency.ParseMavenCoordinate(slug)
 	if artifact.Group != "com.mojang" || !strings.HasPrefix(artifact.Name, "minecraft") || artifact.Version == "" {
-		return artifact, nil
+		return artifact, errors.New("not a minecraft artifact: " + slug)
 	}
 
 	minecraftVersion, err := GetMinecraftVersion(artifact.Version)
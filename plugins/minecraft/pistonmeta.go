@@ -1,8 +1,8 @@
 package minecraft
 
 import (
-	"github.com/mrnavastar/assist/web"
 	"github.com/mrnavastar/lyra/lyra"
+	"net/url"
 	"runtime"
 	"strings"
 	"time"
@@ -114,13 +114,13 @@ type MinecraftVersion struct {
 // TODO: Cache piston meta endpoints so we don't get rate limited
 func GetMinecraftVersion(minecraftVersion string) (version MinecraftVersion, err error) {
 	var meta PistonMeta
-	if err := web.GetJson(pistonMetaUrl, &meta); err != nil {
+	if err := lyra.GetJson(pistonMetaUrl, &meta); err != nil {
 		return MinecraftVersion{}, err
 	}
 
 	for _, versionMeta := range meta.Versions {
 		if versionMeta.ID == minecraftVersion {
-			if err := web.GetJson(versionMeta.URL, &version); err != nil {
+			if err := lyra.GetJson(versionMeta.URL, &version); err != nil {
 				return MinecraftVersion{}, err
 			}
 			break
@@ -148,6 +148,7 @@ func (version MinecraftVersion) GetLibraries() (artifacts []lyra.Artifact) {
 		{
 			artifact := lyra.Dependency.ParseMavenCoordinate(library.Name)
 			artifact.Main = library.Downloads.Artifact.URL
+			artifact.Sha1 = library.Downloads.Artifact.Sha1
 			artifacts = append(artifacts, artifact)
 		}
 	skip:
@@ -159,7 +160,7 @@ func (version MinecraftVersion) GetClientArtifact() (artifact lyra.Artifact) {
 	artifact.Name = "minecraft-client"
 	artifact.Group = "com.mojang"
 	artifact.Version = version.ID
-	artifact.Main = "minecraft://" + strings.TrimPrefix(version.Downloads.Client.URL, "https://") + "?mojmap=" + version.Downloads.ClientMappings.URL
+	artifact.Main = minecraftURL(version.Downloads.Client.URL, version.Downloads.Client.Sha1, version.Downloads.ClientMappings.URL, version.Downloads.ClientMappings.Sha1)
 	return artifact
 }
 
@@ -167,6 +168,16 @@ func (version MinecraftVersion) GetServerArtifact() (artifact lyra.Artifact) {
 	artifact.Name = "minecraft-server"
 	artifact.Group = "com.mojang"
 	artifact.Version = version.ID
-	artifact.Main = "minecraft://" + strings.TrimPrefix(version.Downloads.Server.URL, "https://") + "?mojmap=" + version.Downloads.ServerMappings.URL
+	artifact.Main = minecraftURL(version.Downloads.Server.URL, version.Downloads.Server.Sha1, version.Downloads.ServerMappings.URL, version.Downloads.ServerMappings.Sha1)
 	return artifact
 }
+
+// minecraftURL points at a game jar that gets remapped with its mojang mappings, the known hashes of both travel
+// along so that the downloads can be verified.
+func minecraftURL(jar string, jarSha1 string, mappings string, mappingsSha1 string) string {
+	query := url.Values{}
+	query.Set("mojmap", mappings)
+	query.Set("mojmapSha1", mappingsSha1)
+	query.Set("sha1", jarSha1)
+	return "minecraft://" + strings.TrimPrefix(jar, "https://") + "?" + query.Encode()
+}
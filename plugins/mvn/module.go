@@ -0,0 +1,333 @@
+package mvn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/mrnavastar/lyra/lyra"
+)
+
+// gradleMetadataMarker is left in poms by gradle when it also published a .module file for the same artifact.
+const gradleMetadataMarker = "published-with-gradle-metadata"
+
+// gradleModule is a gradle module metadata file, it describes an artifact as a set of variants that each have their
+// own files and dependencies.
+type gradleModule struct {
+	FormatVersion string          `json:"formatVersion"`
+	Variants      []gradleVariant `json:"variants"`
+}
+
+type gradleVariant struct {
+	Name                  string             `json:"name"`
+	Attributes            map[string]any     `json:"attributes"`
+	AvailableAt           *gradleReference   `json:"available-at"`
+	Dependencies          []gradleDependency `json:"dependencies"`
+	DependencyConstraints []gradleDependency `json:"dependencyConstraints"`
+	Files                 []moduleFile       `json:"files"`
+}
+
+// gradleReference points at a variant that is published by another module, like the jvm part of a multiplatform
+// library.
+type gradleReference struct {
+	URL     string `json:"url"`
+	Group   string `json:"group"`
+	Module  string `json:"module"`
+	Version string `json:"version"`
+}
+
+type gradleDependency struct {
+	Group   string `json:"group"`
+	Module  string `json:"module"`
+	Version struct {
+		Requires string `json:"requires"`
+		Strictly string `json:"strictly"`
+		Prefers  string `json:"prefers"`
+	} `json:"version"`
+	Excludes []struct {
+		Group  string `json:"group"`
+		Module string `json:"module"`
+	} `json:"excludes"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// moduleFile is a file of a variant. Its url is relative to the .module file, and the checksums are known up front.
+type moduleFile struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Sha256 string `json:"sha256"`
+	Sha1   string `json:"sha1"`
+}
+
+const (
+	attributeCategory = "org.gradle.category"
+	attributeUsage    = "org.gradle.usage"
+	attributeJvm      = "org.gradle.jvm.version"
+	attributeElements = "org.gradle.libraryelements"
+	attributeBundling = "org.gradle.dependency.bundling"
+	attributeOs       = "org.gradle.native.operatingSystem"
+	attributeArch     = "org.gradle.native.architecture"
+	usageJavaRuntime  = "java-runtime"
+	usageJavaApi      = "java-api"
+	categoryLibrary   = "library"
+	categoryPlatform  = "platform"
+	categoryEnforced  = "enforced-platform"
+	bundlingExternal  = "external"
+	elementsJar       = "jar"
+)
+
+// unlimitedJvm is used as the target when the version of the JDK is unknown, so that any variant is compatible.
+const unlimitedJvm = 1 << 16
+
+// attribute returns an attribute of a variant as a string, numbers like the jvm version included.
+func attribute(attributes map[string]any, name string) string {
+	switch value := attributes[name].(type) {
+	case string:
+		return value
+	case float64:
+		return fmt.Sprint(int(value))
+	}
+	return ""
+}
+
+func (dep gradleDependency) version() string {
+	for _, version := range []string{dep.Version.Strictly, dep.Version.Requires, dep.Version.Prefers} {
+		if version != "" {
+			return version
+		}
+	}
+	return ""
+}
+
+func (dep gradleDependency) isPlatform() bool {
+	category := attribute(dep.Attributes, attributeCategory)
+	return category == categoryPlatform || category == categoryEnforced
+}
+
+// toDependency turns a gradle dependency into the pom dependency it corresponds to.
+func (dep gradleDependency) toDependency(scope string) dependency {
+	converted := dependency{
+		GroupId:    dep.Group,
+		ArtifactId: dep.Module,
+		Version:    dep.version(),
+		Scope:      scope,
+	}
+	for _, exclude := range dep.Excludes {
+		converted.Exclusions = append(converted.Exclusions, exclusion{GroupId: exclude.Group, ArtifactId: exclude.Module})
+	}
+	return converted
+}
+
+// nativeOs and nativeArch are the values gradle uses for the machine lyra runs on.
+func nativeOs() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "macos"
+	}
+	return runtime.GOOS
+}
+
+func nativeArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86-64"
+	case "386":
+		return "x86"
+	case "arm64":
+		return "aarch64"
+	}
+	return runtime.GOARCH
+}
+
+// selectVariant picks the variant gradle would pick for a java consumer with the given usage that runs on jvm. Of the
+// compatible variants the one built for the newest jvm wins, plain jars beat class directories, and variants that do
+// not bundle their dependencies beat fat ones. Variants for another os or architecture are never picked.
+func (module *gradleModule) selectVariant(usage string, jvm int) *gradleVariant {
+	var selected *gradleVariant
+	best := []int{}
+	for i := range module.Variants {
+		variant := &module.Variants[i]
+		attributes := variant.Attributes
+		if category := attribute(attributes, attributeCategory); category != "" && category != categoryLibrary {
+			continue
+		}
+		if attribute(attributes, attributeUsage) != usage {
+			continue
+		}
+		if system := attribute(attributes, attributeOs); system != "" && system != nativeOs() {
+			continue
+		}
+		if arch := attribute(attributes, attributeArch); arch != "" && arch != nativeArch() {
+			continue
+		}
+		target := 0
+		if version := attribute(attributes, attributeJvm); version != "" {
+			if _, err := fmt.Sscan(version, &target); err != nil || target > jvm {
+				continue
+			}
+		}
+
+		score := []int{
+			target,
+			boolScore(attribute(attributes, attributeElements) == "" || attribute(attributes, attributeElements) == elementsJar),
+			boolScore(attribute(attributes, attributeBundling) == "" || attribute(attributes, attributeBundling) == bundlingExternal),
+			boolScore(attribute(attributes, attributeOs) == ""),
+		}
+		if selected == nil || slices.Compare(score, best) > 0 {
+			selected, best = variant, score
+		}
+	}
+	return selected
+}
+
+func boolScore(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// platformVariant returns the variant a platform module, like a gradle published bom, puts its constraints in.
+func (module *gradleModule) platformVariant() *gradleVariant {
+	for i := range module.Variants {
+		category := attribute(module.Variants[i].Attributes, attributeCategory)
+		if category == categoryPlatform || category == categoryEnforced {
+			return &module.Variants[i]
+		}
+	}
+	return nil
+}
+
+// fetchModule downloads the gradle module metadata published next to a pom.
+func fetchModule(repo lyra.Repository, group string, name string, version string) (*gradleModule, *url.URL, error) {
+	moduleUrl, err := repoFileURL(repo, lyra.Artifact{Group: group, Name: name, Version: version}, "module")
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := lyra.Dependency.ResolveURI(moduleUrl.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	module := gradleModule{}
+	if err := json.Unmarshal(data, &module); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %s", moduleUrl, err)
+	}
+	if !strings.HasPrefix(module.FormatVersion, "1.") {
+		return nil, nil, fmt.Errorf("%s uses unsupported format version %s", moduleUrl, module.FormatVersion)
+	}
+	return &module, moduleUrl, nil
+}
+
+// applyModule replaces what the pom says about dependencies and files with the variants of the module. The runtime
+// variant decides the files and dependencies, dependencies that are missing from the api variant are only needed at
+// runtime. Constraints and the constraints of platforms the variant depends on act like dependency management.
+func (r *resolver) applyModule(pomData *pom, module *gradleModule, moduleUrl *url.URL) error {
+	jvm, err := lyra.Java.Version()
+	if err != nil {
+		jvm = unlimitedJvm
+	}
+
+	runtimeVariant := module.selectVariant(usageJavaRuntime, jvm)
+	if runtimeVariant == nil {
+		runtimeVariant = module.selectVariant(usageJavaApi, jvm)
+	}
+	if runtimeVariant == nil {
+		// Platforms have no files, only constraints
+		platform := module.platformVariant()
+		if platform == nil {
+			return fmt.Errorf("%s has no variant for java %d", moduleUrl, jvm)
+		}
+		pomData.Packaging = "pom"
+		pomData.Dependencies = nil
+		pomData.DependencyManagement.Dependencies = nil
+		for _, constraint := range platform.DependencyConstraints {
+			pomData.DependencyManagement.Dependencies = append(pomData.DependencyManagement.Dependencies, constraint.toDependency(""))
+		}
+		pomData.files = []moduleFile{}
+		return nil
+	}
+
+	// The variant lives in another module, which then is the only dependency
+	if reference := runtimeVariant.AvailableAt; reference != nil {
+		pomData.Packaging = "pom"
+		pomData.Dependencies = []dependency{{GroupId: reference.Group, ArtifactId: reference.Module, Version: reference.Version}}
+		pomData.DependencyManagement.Dependencies = nil
+		pomData.files = []moduleFile{}
+		return nil
+	}
+
+	api := map[string]bool{}
+	if apiVariant := module.selectVariant(usageJavaApi, jvm); apiVariant != nil {
+		for _, dep := range apiVariant.Dependencies {
+			api[dep.Group+":"+dep.Module] = true
+		}
+	}
+
+	var dependencies, managed []dependency
+	for _, dep := range runtimeVariant.Dependencies {
+		if dep.isPlatform() {
+			platform, err := r.effectivePom(dep.Group, dep.Module, dep.version())
+			if err != nil {
+				return fmt.Errorf("failed to import platform %s:%s:%s: %s", dep.Group, dep.Module, dep.version(), err)
+			}
+			managed = append(managed, platform.DependencyManagement.Dependencies...)
+			continue
+		}
+		scope := "compile"
+		if !api[dep.Group+":"+dep.Module] {
+			scope = "runtime"
+		}
+		dependencies = append(dependencies, dep.toDependency(scope))
+	}
+	var constraints []dependency
+	for _, constraint := range runtimeVariant.DependencyConstraints {
+		constraints = append(constraints, constraint.toDependency(""))
+	}
+
+	pomData.Dependencies = dependencies
+	pomData.DependencyManagement.Dependencies = mergeDependencies(constraints, managed)
+	pomData.manage()
+
+	pomData.files = []moduleFile{}
+	for _, file := range runtimeVariant.Files {
+		resolved, err := moduleUrl.Parse(file.URL)
+		if err != nil {
+			return err
+		}
+		file.URL = resolved.String()
+		pomData.files = append(pomData.files, file)
+	}
+	pomData.Packaging = ""
+	if len(pomData.files) == 0 {
+		pomData.Packaging = "pom"
+	} else if extension := strings.TrimPrefix(path.Ext(pomData.files[0].Name), "."); lyra.Extension(extension) != "jar" {
+		pomData.Packaging = extension
+	}
+	return nil
+}
+
+// locateModuleFile uses the file of the selected variant as the main file of an artifact. Only the artifact itself has
+// a variant, files with a classifier or another packaging are still located through the repository layout.
+func locateModuleFile(artifact *lyra.Artifact, pomData *pom) bool {
+	if pomData.files == nil || artifact.Classifier != "" || (artifact.Packaging != "" && artifact.Packaging != pomData.Packaging) {
+		return false
+	}
+	artifact.Packaging = pomData.Packaging
+	// Variants with more than one file are rare, the first one is the jar of the library itself
+	if len(pomData.files) > 0 {
+		file := pomData.files[0]
+		artifact.Main = file.URL
+		artifact.Sha256 = file.Sha256
+		artifact.Sha1 = file.Sha1
+	}
+	return true
+}
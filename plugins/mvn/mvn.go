@@ -3,9 +3,12 @@ package mvn
 import (
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"github.com/mrnavastar/lyra/lyra"
-	"net/http"
 	"net/url"
+	"os"
+	"slices"
+	"strings"
 )
 
 type meta struct {
@@ -13,116 +16,158 @@ type meta struct {
 	GroupId    string   `xml:"groupId"`
 	ArtifactId string   `xml:"artifactId"`
 	Versioning struct {
-		Latest  string `xml:"latest"`
-		Release string `xml:"release"`
+		Latest      string   `xml:"latest"`
+		Release     string   `xml:"release"`
+		Versions    []string `xml:"versions>version"`
+		LastUpdated string   `xml:"lastUpdated"`
 	} `xml:"versioning"`
 }
 
-// TODO : I think the dep struct is wrong - maybe also versions in the meta data
-type pom struct {
-	XMLName      xml.Name `xml:"project"`
-	GroupId      string   `xml:"groupId"`
-	ArtifactId   string   `xml:"artifactId"`
-	Version      string   `xml:"version"`
-	Dependencies struct {
-		Dependency []struct {
-			GroupId    string `xml:"groupId"`
-			ArtifactId string `xml:"artifactId"`
-			Version    string `xml:"version"`
-			Scope      string `xml:"scope"`
-		} `xml:"dependency"`
-	} `xml:"dependencies"`
-}
-
 func init() {
-	lyra.Dependency.RegisterParser(mvnParser)
+	lyra.Dependency.RegisterExcludingParser(mvnParser)
+	lyra.Dependency.RegisterVersionLister(listVersions)
+	lyra.Dependency.RegisterUpdater(updateSnapshot)
+	lyra.Dependency.RegisterPlatformReader(readPlatform)
 }
 
-func getMeta(repo url.URL, artifact lyra.Artifact) (metaData meta, err error) {
-	metaUrl := repo.JoinPath(artifact.Group, artifact.Name).JoinPath("maven-metadata.xml")
+// artifactURL builds the standard maven repository layout url for a file of an artifact. Snapshots live in the
+// directory of their version, but their files are named after the unique fileVersion of a build.
+func artifactURL(repo url.URL, group string, name string, version string, fileVersion string, classifier string, extension string) *url.URL {
+	file := name + "-" + fileVersion
+	if classifier != "" {
+		file += "-" + classifier
+	}
+	return repo.JoinPath(strings.Split(group, ".")...).JoinPath(name, version, file+"."+extension)
+}
 
-	response, err := http.Get(metaUrl.String())
+// repoFileURL locates a file of an artifact in a repository, resolving the unique file name of snapshots.
+func repoFileURL(repo lyra.Repository, artifact lyra.Artifact, extension string) (*url.URL, error) {
+	location, err := repo.Location()
 	if err != nil {
-		return meta{}, err
+		return nil, err
 	}
-	defer response.Body.Close()
-
-	if response.StatusCode != 200 {
-		return meta{}, errors.New("not a valid maven repo: " + response.Status)
+	fileVersion := artifact.Version
+	if lyra.IsSnapshot(artifact.Version) {
+		fileVersion = snapshotFileVersion(repo, artifact, extension)
 	}
-
-	err = xml.NewDecoder(response.Body).Decode(&metaData)
-	return
+	return artifactURL(*location, artifact.Group, artifact.Name, artifact.Version, fileVersion, artifact.Classifier, extension), nil
 }
 
-func getPom(repo url.URL, artifact lyra.Artifact) (pomData pom, err error) {
-	pomUrl := repo.JoinPath(artifact.Group, artifact.Name, artifact.Version, artifact.Name+"-"+artifact.Version, ".pom")
-
-	response, err := http.Get(pomUrl.String())
+func getMeta(repo lyra.Repository, artifact lyra.Artifact) (metaData meta, err error) {
+	location, err := repo.Location()
 	if err != nil {
-		return pom{}, err
+		return meta{}, err
 	}
-	defer response.Body.Close()
+	metaUrl := location.JoinPath(strings.Split(artifact.Group, ".")...).JoinPath(artifact.Name, "maven-metadata.xml")
 
-	if response.StatusCode != 200 {
-		return pom{}, errors.New("not a valid maven repo: " + response.Status)
+	// Metadata changes whenever a version is published, so it is fetched again as often as the repo allows
+	file, err := lyra.Cache.Download(metaUrl, repo.NeedsUpdate(metaUrl.String(), false))
+	if err != nil {
+		return meta{}, err
 	}
-
-	err = xml.NewDecoder(response.Body).Decode(&pomData)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return meta{}, err
+	}
+	err = xml.Unmarshal(data, &metaData)
 	return
 }
 
-func mvnParser(slug string) (lyra.Artifact, error) {
-	artifact := lyra.Dependency.ParseMavenCoordinate(slug)
-
-	var failed []url.URL
-	for _, repo := range lyra.GetCurrentProject().Repos() {
-		// Find latest version if it is not present
-		if len(artifact.Version) == 0 {
-			metaData, err := getMeta(repo, artifact)
-			if err != nil {
-				failed = append(failed, repo)
-				continue
-			}
-			artifact.Version = metaData.Versioning.Latest
+// findVersion looks up the newest release of an artifact in the first repo that knows about it.
+func findVersion(repos []lyra.Repository, artifact lyra.Artifact) (string, error) {
+	errs := &lyra.RepositoryErrors{What: "a version of " + artifact.Group + ":" + artifact.Name}
+	for _, repo := range repos {
+		if err := repo.Serves(artifact); err != nil {
+			errs.Add(repo, fmt.Errorf("skipped, %s", err))
+			continue
 		}
-
-		pomData, err := getPom(repo, artifact)
+		metaData, err := getMeta(repo, artifact)
 		if err != nil {
-			failed = append(failed, repo)
+			errs.Add(repo, err)
 			continue
 		}
+		if metaData.Versioning.Release != "" {
+			return metaData.Versioning.Release, nil
+		}
+		if latest := lyra.LatestVersion(metaData.Versioning.Versions, "", 0); latest != "" {
+			return latest, nil
+		}
+		if metaData.Versioning.Latest != "" {
+			return metaData.Versioning.Latest, nil
+		}
+		errs.Add(repo, errors.New("metadata lists no versions"))
+	}
+	return "", errs
+}
 
-		base := repo.JoinPath(artifact.Group, artifact.Name, artifact.Version)
-		jar := base.JoinPath(artifact.Name + "-" + artifact.Version + ".jar")
-		sources := base.JoinPath(artifact.Name + "-" + artifact.Version + "-sources.jar")
-		docs := base.JoinPath(artifact.Name + "-" + artifact.Version + "-javadocs.jar")
+// readPlatform reads the versions managed by a bom.
+func readPlatform(platform lyra.Artifact) (map[string]string, error) {
+	return newResolver(lyra.GetCurrentProject().Repos()).managedVersions(platform)
+}
 
-		if lyra.PingResource(jar) {
-			artifact.Main = jar.String()
+// listVersions collects the versions of an artifact published in every repo of the project.
+func listVersions(artifact lyra.Artifact) (versions []string, err error) {
+	errs := &lyra.RepositoryErrors{What: "versions of " + artifact.Group + ":" + artifact.Name}
+	found := false
+	for _, repo := range lyra.GetCurrentProject().Repos() {
+		if err := repo.Serves(lyra.Artifact{Group: artifact.Group, Name: artifact.Name}); err != nil {
+			continue
 		}
-		if lyra.PingResource(sources) {
-			artifact.Main = sources.String()
+		metaData, err := getMeta(repo, artifact)
+		if err != nil {
+			errs.Add(repo, err)
+			continue
 		}
-		if lyra.PingResource(docs) {
-			artifact.Docs = docs.String()
+		found = true
+		for _, version := range metaData.Versioning.Versions {
+			if !slices.Contains(versions, version) {
+				versions = append(versions, version)
+			}
 		}
+	}
+	if !found {
+		return nil, errs
+	}
+	return versions, nil
+}
 
-		cmd := []string{"get"}
-		for _, dependency := range pomData.Dependencies.Dependency {
-			var indirect lyra.Artifact
-			indirect.Name = dependency.ArtifactId
-			indirect.Group = artifact.Group
-			indirect.Version = artifact.Version
+func mvnParser(slug string, exclude []string) (lyra.Artifact, error) {
+	artifact := lyra.Dependency.ParseMavenCoordinate(slug)
+	if artifact.Group == "" || artifact.Name == "" {
+		return artifact, errors.New("not a maven coordinate: " + slug)
+	}
+	artifact.Exclude = exclude
 
-			cmd = append(cmd, dependency.GroupId+":"+dependency.ArtifactId+":"+dependency.Version)
-			artifact.Dependencies = append(artifact.Dependencies, indirect)
+	repos := lyra.GetCurrentProject().Repos()
+	r := newResolver(repos)
+	if len(artifact.Version) == 0 {
+		managed, ok, err := r.managed(artifact)
+		if err != nil {
+			return artifact, err
+		}
+		if ok {
+			artifact.Version = managed.version
+			artifact.Platform = managed.platform
+		} else if artifact.Version, err = findVersion(repos, artifact); err != nil {
+			return artifact, err
 		}
+	}
 
-		if err := lyra.Command.Run(cmd...); err != nil {
-			return artifact, nil
+	artifact, err := r.resolve(artifact)
+	if err != nil {
+		return artifact, err
+	}
+
+	// Sources and docs are only fetched for direct dependencies, and only exist for the main jar
+	if artifact.Main != "" && artifact.Classifier == "" && lyra.Extension(artifact.Packaging) == "jar" {
+		sources := strings.TrimSuffix(artifact.Main, ".jar") + "-sources.jar"
+		docs := strings.TrimSuffix(artifact.Main, ".jar") + "-javadoc.jar"
+		if parsed, err := url.Parse(sources); err == nil && lyra.PingResource(parsed) {
+			artifact.Sources = sources
+		}
+		if parsed, err := url.Parse(docs); err == nil && lyra.PingResource(parsed) {
+			artifact.Docs = docs
 		}
-		break
 	}
 	return artifact, nil
 }
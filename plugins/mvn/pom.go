@@ -0,0 +1,284 @@
+package mvn
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/mrnavastar/lyra/lyra"
+)
+
+type exclusion struct {
+	GroupId    string `xml:"groupId"`
+	ArtifactId string `xml:"artifactId"`
+}
+
+type dependency struct {
+	GroupId    string      `xml:"groupId"`
+	ArtifactId string      `xml:"artifactId"`
+	Version    string      `xml:"version"`
+	Type       string      `xml:"type"`
+	Classifier string      `xml:"classifier"`
+	Scope      string      `xml:"scope"`
+	Optional   string      `xml:"optional"`
+	Exclusions []exclusion `xml:"exclusions>exclusion"`
+}
+
+type pom struct {
+	XMLName xml.Name `xml:"project"`
+	Parent  struct {
+		GroupId    string `xml:"groupId"`
+		ArtifactId string `xml:"artifactId"`
+		Version    string `xml:"version"`
+	} `xml:"parent"`
+	GroupId              string     `xml:"groupId"`
+	ArtifactId           string     `xml:"artifactId"`
+	Version              string     `xml:"version"`
+	Packaging            string     `xml:"packaging"`
+	Properties           properties `xml:"properties"`
+	DependencyManagement struct {
+		Dependencies []dependency `xml:"dependencies>dependency"`
+	} `xml:"dependencyManagement"`
+	Dependencies []dependency `xml:"dependencies>dependency"`
+
+	// repo is the repository the pom was found in, artifacts are fetched from the same place
+	repo lyra.Repository
+	// gradleMetadata is set when a .module file was published along with the pom
+	gradleMetadata bool
+	// files are the files of the variant selected from the .module file, nil when the pom is all there is
+	files []moduleFile
+}
+
+// properties decodes the free-form <properties> block of a pom.
+type properties map[string]string
+
+var propertyPattern = regexp.MustCompile(`\$\{([^}]+)}`)
+
+func (props *properties) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	*props = properties{}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			var value string
+			if err := decoder.DecodeElement(&value, &element); err != nil {
+				return err
+			}
+			(*props)[element.Name.Local] = strings.TrimSpace(value)
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+func (dep dependency) key() string {
+	return managementKey(dep.GroupId, dep.ArtifactId, dep.Type, dep.Classifier)
+}
+
+func (dep dependency) isOptional() bool {
+	return strings.TrimSpace(dep.Optional) == "true"
+}
+
+func (dep dependency) excludes(other dependency) bool {
+	for _, exclusion := range dep.Exclusions {
+		if (exclusion.GroupId == "*" || exclusion.GroupId == other.GroupId) &&
+			(exclusion.ArtifactId == "*" || exclusion.ArtifactId == other.ArtifactId) {
+			return true
+		}
+	}
+	return false
+}
+
+func managementKey(group string, name string, packaging string, classifier string) string {
+	if packaging == "" {
+		packaging = "jar"
+	}
+	return strings.Join([]string{group, name, packaging, classifier}, ":")
+}
+
+func readPom(file string) (*pom, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pomData := pom{}
+	if err := xml.Unmarshal(data, &pomData); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", file, err)
+	}
+	pomData.gradleMetadata = bytes.Contains(data, []byte(gradleMetadataMarker))
+	return &pomData, nil
+}
+
+// interpolate replaces every ${property} in value, properties may reference each other so this repeats until nothing
+// changes.
+func (pomData *pom) interpolate(value string) string {
+	for i := 0; i < 10 && strings.Contains(value, "${"); i++ {
+		replaced := propertyPattern.ReplaceAllStringFunc(value, func(match string) string {
+			if property, ok := pomData.Properties[match[2:len(match)-1]]; ok {
+				return property
+			}
+			return match
+		})
+		if replaced == value {
+			break
+		}
+		value = replaced
+	}
+	return value
+}
+
+func (pomData *pom) interpolateDependencies(dependencies []dependency) {
+	for i := range dependencies {
+		dep := &dependencies[i]
+		dep.GroupId = pomData.interpolate(dep.GroupId)
+		dep.ArtifactId = pomData.interpolate(dep.ArtifactId)
+		dep.Version = pomData.interpolate(dep.Version)
+		dep.Type = pomData.interpolate(dep.Type)
+		dep.Classifier = pomData.interpolate(dep.Classifier)
+		dep.Scope = pomData.interpolate(dep.Scope)
+		dep.Optional = pomData.interpolate(dep.Optional)
+	}
+}
+
+// inherit merges a parent pom into this one, values declared by the child always win.
+func (pomData *pom) inherit(parent *pom) {
+	if pomData.GroupId == "" {
+		pomData.GroupId = parent.GroupId
+	}
+	if pomData.Version == "" {
+		pomData.Version = parent.Version
+	}
+
+	if pomData.Properties == nil {
+		pomData.Properties = properties{}
+	}
+	for name, value := range parent.Properties {
+		if _, ok := pomData.Properties[name]; !ok {
+			pomData.Properties[name] = value
+		}
+	}
+	pomData.Properties["project.parent.groupId"] = parent.GroupId
+	pomData.Properties["project.parent.artifactId"] = parent.ArtifactId
+	pomData.Properties["project.parent.version"] = parent.Version
+
+	pomData.DependencyManagement.Dependencies = mergeDependencies(pomData.DependencyManagement.Dependencies, parent.DependencyManagement.Dependencies)
+	pomData.Dependencies = mergeDependencies(pomData.Dependencies, parent.Dependencies)
+}
+
+// mergeDependencies appends every inherited dependency that isn't already declared.
+func mergeDependencies(declared []dependency, inherited []dependency) []dependency {
+	keys := map[string]bool{}
+	for _, dep := range declared {
+		keys[dep.key()] = true
+	}
+	for _, dep := range inherited {
+		if !keys[dep.key()] {
+			declared = append(declared, dep)
+		}
+	}
+	return declared
+}
+
+// manage fills in versions, scopes and exclusions from <dependencyManagement>.
+func (pomData *pom) manage() {
+	managed := map[string]dependency{}
+	for _, dep := range pomData.DependencyManagement.Dependencies {
+		if _, ok := managed[dep.key()]; !ok {
+			managed[dep.key()] = dep
+		}
+	}
+
+	for i := range pomData.Dependencies {
+		dep := &pomData.Dependencies[i]
+		management, ok := managed[dep.key()]
+		if !ok {
+			continue
+		}
+		if dep.Version == "" {
+			dep.Version = management.Version
+		}
+		if dep.Scope == "" {
+			dep.Scope = management.Scope
+		}
+		dep.Exclusions = append(dep.Exclusions, management.Exclusions...)
+	}
+}
+
+// effectivePom fetches a pom and builds its effective model: parents are inherited, properties are interpolated,
+// BOMs are imported and dependency management is applied. When gradle published a .module file along with the pom,
+// that is used instead, see applyModule.
+func (r *resolver) effectivePom(group string, name string, version string) (*pom, error) {
+	coordinate := strings.Join([]string{group, name, version}, ":")
+	if cached, ok := r.poms[coordinate]; ok {
+		if cached == nil {
+			return nil, fmt.Errorf("cyclic pom hierarchy at %s", coordinate)
+		}
+		return cached, nil
+	}
+	r.poms[coordinate] = nil
+
+	pomData, err := r.fetchPom(group, name, version)
+	if err != nil {
+		delete(r.poms, coordinate)
+		return nil, err
+	}
+
+	// Module metadata is complete on its own, the pom is only a fallback when it can't be read
+	if pomData.gradleMetadata {
+		if module, moduleUrl, err := fetchModule(pomData.repo, group, name, version); err == nil {
+			if err := r.applyModule(pomData, module, moduleUrl); err != nil {
+				delete(r.poms, coordinate)
+				return nil, err
+			}
+			r.poms[coordinate] = pomData
+			return pomData, nil
+		}
+	}
+
+	if pomData.Parent.ArtifactId != "" {
+		parent, err := r.effectivePom(pomData.Parent.GroupId, pomData.Parent.ArtifactId, pomData.Parent.Version)
+		if err != nil {
+			delete(r.poms, coordinate)
+			return nil, fmt.Errorf("failed to resolve parent of %s: %s", coordinate, err)
+		}
+		pomData.inherit(parent)
+	}
+
+	if pomData.Properties == nil {
+		pomData.Properties = properties{}
+	}
+	for _, prefix := range []string{"project.", "pom."} {
+		pomData.Properties[prefix+"groupId"] = pomData.GroupId
+		pomData.Properties[prefix+"artifactId"] = pomData.ArtifactId
+		pomData.Properties[prefix+"version"] = pomData.Version
+	}
+	pomData.interpolateDependencies(pomData.DependencyManagement.Dependencies)
+	pomData.interpolateDependencies(pomData.Dependencies)
+
+	// Import BOMs, entries declared directly in this pom take precedence over imported ones
+	var imported []dependency
+	for _, dep := range pomData.DependencyManagement.Dependencies {
+		if dep.Scope != "import" || dep.Type != "pom" {
+			continue
+		}
+		bom, err := r.effectivePom(dep.GroupId, dep.ArtifactId, dep.Version)
+		if err != nil {
+			delete(r.poms, coordinate)
+			return nil, fmt.Errorf("failed to import bom %s:%s:%s into %s: %s", dep.GroupId, dep.ArtifactId, dep.Version, coordinate, err)
+		}
+		imported = append(imported, bom.DependencyManagement.Dependencies...)
+	}
+	pomData.DependencyManagement.Dependencies = mergeDependencies(pomData.DependencyManagement.Dependencies, imported)
+	pomData.manage()
+
+	r.poms[coordinate] = pomData
+	return pomData, nil
+}
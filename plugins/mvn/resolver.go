@@ -0,0 +1,320 @@
+package mvn
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mrnavastar/lyra/lyra"
+)
+
+// resolver builds the dependency graph of a maven artifact. It is not safe for concurrent use.
+type resolver struct {
+	repos []lyra.Repository
+	poms  map[string]*pom
+	// platforms maps the key of every artifact managed by a platform of the project to its version and platform
+	platforms map[string]managedVersion
+}
+
+type managedVersion struct {
+	version  string
+	platform string
+}
+
+type node struct {
+	artifact   lyra.Artifact
+	pom        *pom
+	exclusions []dependency
+	children   []*node
+	// expanded is the occurrence of the same coordinate whose dependencies were followed, if it isn't this one
+	expanded *node
+}
+
+func newResolver(repos []lyra.Repository) *resolver {
+	return &resolver{
+		repos: repos,
+		poms:  map[string]*pom{},
+	}
+}
+
+// fetchPom downloads a pom from the first repository, in project order, that has it.
+func (r *resolver) fetchPom(group string, name string, version string) (*pom, error) {
+	errs := &lyra.RepositoryErrors{What: group + ":" + name + ":" + version}
+	for _, repo := range r.repos {
+		if err := repo.Serves(lyra.Artifact{Group: group, Name: name, Version: version}); err != nil {
+			errs.Add(repo, fmt.Errorf("skipped, %s", err))
+			continue
+		}
+		pomUrl, err := repoFileURL(repo, lyra.Artifact{Group: group, Name: name, Version: version}, "pom")
+		if err != nil {
+			errs.Add(repo, err)
+			continue
+		}
+
+		file, err := lyra.Dependency.ResolveURI(pomUrl.String())
+		if err != nil {
+			errs.Add(repo, err)
+			continue
+		}
+
+		pomData, err := readPom(file)
+		if err != nil {
+			return nil, err
+		}
+		pomData.repo = repo
+		return pomData, nil
+	}
+	return nil, errs
+}
+
+// isTransitive reports whether a dependency declared in a pom ends up on the consumers classpath.
+func isTransitive(dep dependency) bool {
+	if dep.isOptional() {
+		return false
+	}
+	switch dep.Scope {
+	case "", "compile", "runtime":
+		return true
+	}
+	return false
+}
+
+// excluded reports whether any exclusion collected along the path to a node matches dep.
+func (current *node) excluded(dep dependency) bool {
+	for _, exclusion := range current.exclusions {
+		if exclusion.excludes(dep) {
+			return true
+		}
+	}
+	return false
+}
+
+// locate fills in the download location of an artifact. Files listed by gradle module metadata are used as they are,
+// otherwise the repository its pom was found in is asked first, when it does not have the file the other repositories
+// are tried in order. Without a declared packaging the packaging of the pom is used, and pom packaging has no file at
+// all.
+func (r *resolver) locate(artifact *lyra.Artifact, pomData *pom) error {
+	if locateModuleFile(artifact, pomData) {
+		return nil
+	}
+	packaging := artifact.Packaging
+	if packaging == "" && artifact.Classifier == "" && pomData.Packaging != "" {
+		packaging = pomData.Packaging
+	}
+	if packaging == "pom" {
+		artifact.Packaging = packaging
+		return nil
+	}
+	extension := lyra.Extension(packaging)
+	if extension != "jar" {
+		artifact.Packaging = packaging
+	}
+
+	repos := []lyra.Repository{pomData.repo}
+	for _, repo := range r.repos {
+		if !repo.SameAs(pomData.repo) {
+			repos = append(repos, repo)
+		}
+	}
+
+	errs := &lyra.RepositoryErrors{What: "the " + extension + " of " + artifact.Coordinate()}
+	for _, repo := range repos {
+		if err := repo.Serves(*artifact); err != nil {
+			errs.Add(repo, fmt.Errorf("skipped, %s", err))
+			continue
+		}
+		fileUrl, err := repoFileURL(repo, *artifact, extension)
+		if err != nil {
+			errs.Add(repo, err)
+			continue
+		}
+
+		file := fileUrl.String()
+		ok, err := available(file)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			errs.Add(repo, errors.New("not found"))
+			continue
+		}
+		artifact.Main = file
+		return nil
+	}
+	return errs
+}
+
+// available checks whether a file exists without downloading it, files in the cache count even when offline.
+func available(uri string) (bool, error) {
+	if _, ok, err := lyra.Cache.Lookup(uri); ok || err != nil {
+		return ok, err
+	}
+	parsed, err := url.Parse(uri)
+	return err == nil && lyra.PingResource(parsed), nil
+}
+
+// resolve walks the graph breadth first so that the first occurrence of an artifact is also the one nearest to
+// the root. The dependencies of every version are followed once, and later occurrences of the same version share
+// them. So every occurrence comes with all it needs, and any of them can take over when the project drops the nearest
+// one with an exclusion or substitution.
+func (r *resolver) resolve(root lyra.Artifact) (lyra.Artifact, error) {
+	rootPom, err := r.effectivePom(root.Group, root.Name, root.Version)
+	if err != nil {
+		return root, err
+	}
+	if err := r.locate(&root, rootPom); err != nil {
+		return root, err
+	}
+
+	// Exclusions of the root are never fetched, they may not even exist in any repository
+	exclude := root.Exclude
+	tree := &node{artifact: root, pom: rootPom}
+	expanded := map[string]*node{root.Coordinate(): tree}
+	queue := []*node{tree}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, dep := range current.pom.Dependencies {
+			if !isTransitive(dep) || current.excluded(dep) {
+				continue
+			}
+
+			child := &node{artifact: dep.artifact()}
+			if child.artifact.Matches(exclude) {
+				continue
+			}
+			if dep.Scope == lyra.ScopeRuntime {
+				child.artifact.Scope = lyra.ScopeRuntime
+			}
+			// Platforms of the project align transitive versions, like the dependency management of a root pom
+			managed, ok, err := r.managed(child.artifact)
+			if err != nil {
+				return root, err
+			}
+			if ok && managed.version != child.artifact.Version {
+				child.artifact.Version = managed.version
+				child.artifact.Platform = managed.platform
+			}
+			current.children = append(current.children, child)
+
+			coordinate := child.artifact.Coordinate()
+			if same, ok := expanded[coordinate]; ok {
+				child.artifact.Main = same.artifact.Main
+				child.artifact.Packaging = same.artifact.Packaging
+				child.expanded = same
+				continue
+			}
+			expanded[coordinate] = child
+
+			child.pom, err = r.effectivePom(child.artifact.Group, child.artifact.Name, child.artifact.Version)
+			if err != nil {
+				return root, fmt.Errorf("failed to resolve %s (required by %s:%s): %s", coordinate, current.artifact.Group, current.artifact.Name, err)
+			}
+			if err := r.locate(&child.artifact, child.pom); err != nil {
+				return root, err
+			}
+			child.exclusions = append(append([]dependency{}, current.exclusions...), dep)
+			queue = append(queue, child)
+		}
+	}
+	return tree.toArtifact(map[string]bool{}), nil
+}
+
+// managedVersions reads the dependency management of a bom, keyed like lyra.Artifact.Key.
+func (r *resolver) managedVersions(platform lyra.Artifact) (map[string]string, error) {
+	bom, err := r.effectivePom(platform.Group, platform.Name, platform.Version)
+	if err != nil {
+		return nil, err
+	}
+	managed := map[string]string{}
+	for _, dep := range bom.DependencyManagement.Dependencies {
+		if dep.Type == "pom" || dep.Version == "" {
+			continue
+		}
+		key := dep.artifact().Key()
+		if _, ok := managed[key]; !ok {
+			managed[key] = normalizeVersion(dep.Version)
+		}
+	}
+	return managed, nil
+}
+
+// managed returns the version the platforms of the project give an artifact, platforms declared first win.
+func (r *resolver) managed(artifact lyra.Artifact) (managedVersion, bool, error) {
+	if r.platforms == nil {
+		r.platforms = map[string]managedVersion{}
+		for _, platform := range lyra.GetCurrentProject().Platforms() {
+			versions, err := r.managedVersions(platform)
+			if err != nil {
+				r.platforms = nil
+				return managedVersion{}, false, fmt.Errorf("failed to read platform %s: %s", platform.Coordinate(), err)
+			}
+			for key, version := range versions {
+				if _, ok := r.platforms[key]; !ok {
+					r.platforms[key] = managedVersion{version: version, platform: platform.Coordinate()}
+				}
+			}
+		}
+	}
+	managed, ok := r.platforms[artifact.Key()]
+	return managed, ok, nil
+}
+
+// artifact turns a declared dependency into the artifact it refers to. A test-jar is the jar with the tests
+// classifier.
+func (dep dependency) artifact() lyra.Artifact {
+	artifact := lyra.Artifact{
+		Name:       dep.ArtifactId,
+		Group:      dep.GroupId,
+		Version:    normalizeVersion(dep.Version),
+		Packaging:  dep.Type,
+		Classifier: dep.Classifier,
+	}
+	if artifact.Packaging == "test-jar" && artifact.Classifier == "" {
+		artifact.Classifier = "tests"
+	}
+	if lyra.Extension(artifact.Packaging) == "jar" {
+		artifact.Packaging = ""
+	}
+	return artifact
+}
+
+// toArtifact turns the graph below a node into nested artifacts. An artifact that depends on one of its own
+// dependents ends the cycle as a leaf, the rest of the graph is already on the path to it.
+func (current *node) toArtifact(path map[string]bool) lyra.Artifact {
+	artifact := current.artifact
+	artifact.Dependencies = nil
+	coordinate := artifact.Coordinate()
+	if path[coordinate] {
+		return artifact
+	}
+	path[coordinate] = true
+	defer delete(path, coordinate)
+
+	children := current.children
+	if current.expanded != nil {
+		children = current.expanded.children
+	}
+	for _, child := range children {
+		artifact.Dependencies = append(artifact.Dependencies, child.toArtifact(path))
+	}
+	return artifact
+}
+
+// normalizeVersion picks a concrete version out of a maven version range by using its lower bound, or the upper
+// bound if no lower bound is given.
+func normalizeVersion(version string) string {
+	if !strings.HasPrefix(version, "[") && !strings.HasPrefix(version, "(") {
+		return version
+	}
+
+	bounds := strings.Split(strings.Trim(version, "[]()"), ",")
+	for _, bound := range bounds {
+		if bound = strings.TrimSpace(bound); bound != "" {
+			return bound
+		}
+	}
+	return version
+}
@@ -0,0 +1,99 @@
+package mvn
+
+import (
+	"encoding/xml"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mrnavastar/lyra/lyra"
+)
+
+// snapshotMeta is the maven-metadata.xml inside the directory of a snapshot version.
+type snapshotMeta struct {
+	XMLName    xml.Name `xml:"metadata"`
+	Versioning struct {
+		Snapshot struct {
+			Timestamp   string `xml:"timestamp"`
+			BuildNumber int    `xml:"buildNumber"`
+			LocalCopy   bool   `xml:"localCopy"`
+		} `xml:"snapshot"`
+		SnapshotVersions []struct {
+			Classifier string `xml:"classifier"`
+			Extension  string `xml:"extension"`
+			Value      string `xml:"value"`
+		} `xml:"snapshotVersions>snapshotVersion"`
+	} `xml:"versioning"`
+}
+
+func snapshotMetaURL(repo url.URL, group string, name string, version string) *url.URL {
+	return repo.JoinPath(strings.Split(group, ".")...).JoinPath(name, version, "maven-metadata.xml")
+}
+
+// snapshotFileVersion returns the version used in the file names of a snapshot, deployments give every build a
+// unique name like name-1.0-20240101.120000-3.jar. Snapshots without metadata, or that were only installed locally,
+// keep the plain -SNAPSHOT name.
+func snapshotFileVersion(repo lyra.Repository, artifact lyra.Artifact, extension string) string {
+	version := artifact.Version
+	location, err := repo.Location()
+	if err != nil {
+		return version
+	}
+	metaUrl := snapshotMetaURL(*location, artifact.Group, artifact.Name, version)
+	file, err := lyra.Cache.Download(metaUrl, repo.NeedsUpdate(metaUrl.String(), true))
+	if err != nil {
+		return version
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return version
+	}
+	metaData := snapshotMeta{}
+	if err := xml.Unmarshal(data, &metaData); err != nil {
+		return version
+	}
+
+	for _, snapshotVersion := range metaData.Versioning.SnapshotVersions {
+		if snapshotVersion.Extension == extension && snapshotVersion.Classifier == artifact.Classifier && snapshotVersion.Value != "" {
+			return snapshotVersion.Value
+		}
+	}
+	snapshot := metaData.Versioning.Snapshot
+	if snapshot.LocalCopy || snapshot.Timestamp == "" {
+		return version
+	}
+	return strings.TrimSuffix(version, "-SNAPSHOT") + "-" + snapshot.Timestamp + "-" + strconv.Itoa(snapshot.BuildNumber)
+}
+
+// updateSnapshot re-resolves a snapshot dependency once the snapshot update policy of its repository is due, it
+// reports a change only when a newer build was published.
+func updateSnapshot(artifact lyra.Artifact) (lyra.Artifact, bool, error) {
+	if !lyra.IsSnapshot(artifact.Version) || artifact.Main == "" {
+		return artifact, false, nil
+	}
+
+	for _, repo := range lyra.GetCurrentProject().Repos() {
+		if !strings.HasPrefix(artifact.Main, strings.TrimSuffix(repo.URL, "/")+"/") {
+			continue
+		}
+		location, err := repo.Location()
+		if err != nil {
+			return artifact, false, err
+		}
+		metaUrl := snapshotMetaURL(*location, artifact.Group, artifact.Name, artifact.Version)
+		if !repo.NeedsUpdate(metaUrl.String(), true) {
+			return artifact, false, nil
+		}
+		if _, err := lyra.Cache.Download(metaUrl, true); err != nil {
+			return artifact, false, err
+		}
+
+		updated, err := mvnParser(artifact.Coordinate(), artifact.Exclude)
+		if err != nil {
+			return artifact, false, err
+		}
+		return updated, updated.Main != artifact.Main, nil
+	}
+	return artifact, false, nil
+}